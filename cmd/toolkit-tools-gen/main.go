@@ -15,6 +15,7 @@ import (
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
 )
 
 var (
@@ -89,6 +90,8 @@ type Tool struct {
 	ArgumentType string
 	Arguments    *ToolArguments
 	PackageName  string
+	Timeout      time.Duration
+	Confirm      bool
 }
 
 func (t *Tool) GetArguments() []Arg {
@@ -200,6 +203,18 @@ func (s *ToolScanner) ScanTools() error {
 							if strings.HasPrefix(comment.Text, "// +tool:description=") {
 								tool.Description = strings.TrimPrefix(comment.Text, "// +tool:description=")
 							}
+							if strings.HasPrefix(comment.Text, "// +tool:timeout=") {
+								rawTimeout := strings.TrimPrefix(comment.Text, "// +tool:timeout=")
+								timeout, err := time.ParseDuration(rawTimeout)
+								if err != nil || timeout <= 0 {
+									typeLogger.Error("invalid tool timeout", "timeout", rawTimeout, "err", err)
+									continue
+								}
+								tool.Timeout = timeout
+							}
+							if comment.Text == "// +tool:confirm" || comment.Text == "// +tool:confirm=true" {
+								tool.Confirm = true
+							}
 						}
 
 						structType, isStructType := typeSpec.Type.(*ast.StructType)
@@ -434,6 +449,9 @@ import (
 	"github.com/emilkje/go-openai-toolkit/toolkit"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
+	{{- if .Timeout}}
+	"time"
+	{{- end}}
 )
 
 func ({{.ReceiverName}} *{{.TypeName}}) Definition() openai.FunctionDefinition {
@@ -458,6 +476,22 @@ func ({{.ReceiverName}} *{{.TypeName}}) Definition() openai.FunctionDefinition {
 func New{{.TypeName}}() *{{.TypeName}} {
 	return &{{.TypeName}}{&toolkit.ToolArgs[{{.ArgumentType}}]{}}
 }
+
+func ({{.ReceiverName}} *{{.TypeName}}) NewInstance() toolkit.Definable {
+	return New{{.TypeName}}()
+}
+{{- if .Timeout}}
+
+func ({{.ReceiverName}} *{{.TypeName}}) Timeout() time.Duration {
+	return {{.Timeout}}
+}
+{{- end}}
+{{- if .Confirm}}
+
+func ({{.ReceiverName}} *{{.TypeName}}) RequiresConfirmation() bool {
+	return true
+}
+{{- end}}
 `
 
 type Definition struct {
@@ -469,6 +503,8 @@ type Definition struct {
 	ArgumentType string
 	RequiredArgs []string
 	PackageName  string
+	Timeout      string
+	Confirm      bool
 }
 
 func join(sep string, s []string, surroundingStr string) string {
@@ -481,6 +517,31 @@ func join(sep string, s []string, surroundingStr string) string {
 	return surroundingStr + strings.Join(s, sep) + surroundingStr
 }
 
+// durationLiteral renders d as a Go expression, e.g. 30 * time.Second.
+// A zero duration renders as an empty string.
+func durationLiteral(d time.Duration) string {
+	if d == 0 {
+		return ""
+	}
+
+	units := []struct {
+		unit time.Duration
+		name string
+	}{
+		{time.Hour, "time.Hour"},
+		{time.Minute, "time.Minute"},
+		{time.Second, "time.Second"},
+		{time.Millisecond, "time.Millisecond"},
+		{time.Microsecond, "time.Microsecond"},
+	}
+	for _, u := range units {
+		if d%u.unit == 0 {
+			return strconv.FormatInt(int64(d/u.unit), 10) + " * " + u.name
+		}
+	}
+	return "time.Duration(" + strconv.FormatInt(int64(d), 10) + ")"
+}
+
 func (g *Generator) generateToolFileContent(tool *Tool) (string, error) {
 
 	// Create a new template
@@ -511,6 +572,8 @@ func (g *Generator) generateToolFileContent(tool *Tool) (string, error) {
 		RequiredArgs: requiredTools,
 		PackageName:  tool.PackageName,
 		ArgumentType: tool.ArgumentType,
+		Timeout:      durationLiteral(tool.Timeout),
+		Confirm:      tool.Confirm,
 	}
 
 	err = t.Execute(&buf, def)
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const toolSource = `package tools
+
+import "github.com/emilkje/go-openai-toolkit/toolkit"
+
+type Args struct {
+	Text string ` + "`json:\"text\" desc:\"The text.\"`" + `
+}
+
+// DeleteTool deletes things
+// +tool:name=delete_tool
+// +tool:description=Deletes things
+// +tool:timeout=30s
+// +tool:confirm
+type DeleteTool struct {
+	toolkit.Tool[Args]
+}
+
+// ReadTool reads things
+// +tool:name=read_tool
+// +tool:description=Reads things
+type ReadTool struct {
+	toolkit.Tool[Args]
+}
+`
+
+func TestGenerateMarkers(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tools.go"), []byte(toolSource), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	scanner := NewScanner(dir)
+	if err := scanner.ScanTools(); err != nil {
+		t.Fatalf("ScanTools() error = %v", err)
+	}
+	scanner.ScanArguments()
+	tools := scanner.GetTools()
+	if len(tools) != 2 {
+		t.Fatalf("found %d tools, want 2", len(tools))
+	}
+
+	tests := []struct {
+		typeName    string
+		wantMethods map[string]string
+	}{
+		{
+			typeName: "DeleteTool",
+			wantMethods: map[string]string{
+				"Definition":           `"delete_tool"`,
+				"NewInstance":          "NewDeleteTool()",
+				"Timeout":              "30 * time.Second",
+				"RequiresConfirmation": "return true",
+			},
+		},
+		{
+			typeName: "ReadTool",
+			wantMethods: map[string]string{
+				"Definition":  `"read_tool"`,
+				"NewInstance": "NewReadTool()",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typeName, func(t *testing.T) {
+			content, err := NewGenerator(scanner).generateToolFileContent(tools[tt.typeName])
+			if err != nil {
+				t.Fatalf("generateToolFileContent() error = %v", err)
+			}
+			file, err := parser.ParseFile(token.NewFileSet(), "generated.go", content, 0)
+			if err != nil {
+				t.Fatalf("generated code does not parse: %v\n%s", err, content)
+			}
+
+			methods := make(map[string]string)
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv != nil {
+					methods[fn.Name.Name] = content[fn.Body.Pos()-file.FileStart : fn.Body.End()-file.FileStart]
+				}
+			}
+			if len(methods) != len(tt.wantMethods) {
+				t.Errorf("generated methods = %v, want %d methods", keys(methods), len(tt.wantMethods))
+			}
+			for name, wantBody := range tt.wantMethods {
+				if body, ok := methods[name]; !ok || !strings.Contains(body, wantBody) {
+					t.Errorf("method %s = %q, want a body containing %q", name, body, wantBody)
+				}
+			}
+		})
+	}
+}
+
+func keys(m map[string]string) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	return names
+}
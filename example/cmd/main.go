@@ -19,10 +19,7 @@ func main() {
 	tk := toolkit.NewToolkit()
 
 	// Register the tools
-	tk.RegisterTool(
-		tools.NewGeocodeTool(),
-		tools.NewWeatherTool(),
-	)
+	tk.RegisterContextTool(tools.NewGeocodeTool(), tools.NewWeatherTool())
 
 	// Create a new runtime
 	client := openai.NewClientWithConfig(newConfigFromEnv())
@@ -1,7 +1,9 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/emilkje/go-openai-toolkit/toolkit"
 	"net/http"
@@ -19,7 +21,7 @@ type GeocodeArgs struct {
 // +tool:description=Geocode tool geocodes an address and returns the latitude and longitude.
 type GeocodeTool struct{ toolkit.Tool[GeocodeArgs] }
 
-func (g *GeocodeTool) Execute() string {
+func (g *GeocodeTool) ExecuteContext(ctx context.Context) (string, error) {
 
 	// url encode address argument
 	address := url.QueryEscape(g.GetArguments().Address)
@@ -27,21 +29,29 @@ func (g *GeocodeTool) Execute() string {
 	client := &http.Client{}
 	apikey := os.Getenv("GOOGLE_API_KEY")
 
-	res, err := client.Get("https://maps.googleapis.com/maps/api/geocode/json?address=" + address + "&key=" + apikey)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://maps.googleapis.com/maps/api/geocode/json?address="+address+"&key="+apikey, nil)
+	if err != nil {
+		return "", err
+	}
 
+	res, err := client.Do(req)
 	if err != nil {
-		return err.Error()
+		return "", err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return fmt.Sprintf("Error: %s", res.Status)
+		return "", fmt.Errorf("unexpected status: %s", res.Status)
 	}
 
-	defer res.Body.Close()
 	var result geocodeResponse
 	err = json.NewDecoder(res.Body).Decode(&result)
 	if err != nil {
-		return err.Error()
+		return "", err
+	}
+
+	if len(result.Results) == 0 {
+		return "", errors.New("no results found for address")
 	}
 
 	lat := result.Results[0].Geometry.Location.Lat
@@ -49,7 +59,7 @@ func (g *GeocodeTool) Execute() string {
 
 	return fmt.Sprintf("Latitude: %s, Longitude: %s",
 		strconv.FormatFloat(lat, 'f', -1, 64),
-		strconv.FormatFloat(lon, 'f', -1, 64))
+		strconv.FormatFloat(lon, 'f', -1, 64)), nil
 }
 
 type geocodeResponse struct {
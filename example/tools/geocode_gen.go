@@ -28,3 +28,7 @@ func (g *GeocodeTool) Definition() openai.FunctionDefinition {
 func NewGeocodeTool() *GeocodeTool {
 	return &GeocodeTool{&toolkit.ToolArgs[GeocodeArgs]{}}
 }
+
+func (g *GeocodeTool) NewInstance() toolkit.Definable {
+	return NewGeocodeTool()
+}
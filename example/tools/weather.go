@@ -1,7 +1,9 @@
 package tools
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/emilkje/go-openai-toolkit/toolkit"
 	"net/http"
@@ -15,61 +17,80 @@ type WeatherToolArgs struct {
 // WeatherTool is a tool that reports the current weather for a location
 // +tool:name=weather_tool
 // +tool:description=WeatherTool reports the current weather for a location
+// +tool:timeout=10s
 type WeatherTool struct {
 	toolkit.Tool[WeatherToolArgs]
 }
 
-func (g *WeatherTool) Execute() string {
+func (g *WeatherTool) ExecuteContext(ctx context.Context) (string, error) {
 
 	client := &http.Client{}
 	queryParams := fmt.Sprintf("lat=%f&lon=%f",
 		g.GetArguments().Latitude,
 		g.GetArguments().Longitude)
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://api.met.no/weatherapi/locationforecast/2.0/compact?"+queryParams, nil)
+	if err != nil {
+		return "", err
+	}
 	// add Accept and User-Agent headers
-	req, err := http.NewRequest("GET", "https://api.met.no/weatherapi/locationforecast/2.0/compact?"+queryParams, nil)
 	req.Header.Add("Accept", "application/json")
 	req.Header.Add("User-Agent", "go-openai-toolkit")
 
 	res, err := client.Do(req)
-
 	if err != nil {
-		return fmt.Sprintf("Error: %v", err)
+		return "", err
 	}
+	defer res.Body.Close()
 
 	if res.StatusCode != 200 {
-		return fmt.Sprintf("Error: %v", res.Status)
+		return "", fmt.Errorf("unexpected status: %s", res.Status)
 	}
 
-	defer res.Body.Close()
-	// decode the body into a string
-	data := make(map[string]interface{})
-	json.NewDecoder(res.Body).Decode(&data)
+	var forecast forecastResponse
+	if err = json.NewDecoder(res.Body).Decode(&forecast); err != nil {
+		return "", err
+	}
 
 	// metadata about the values is found inside .properties.meta.units as a key value pair
 	// the actual values are found inside .properties.timeseries
 
 	// lets return the metadata and the first timeseries
-	metadata := data["properties"].(map[string]interface{})["meta"].(map[string]interface{})["units"].(map[string]interface{})
+	if len(forecast.Properties.Timeseries) == 0 {
+		return "", errors.New("no forecast found for location")
+	}
 
 	// format the metadata as a string in the form of key=value\n
 	metadataStr := "#metadata:\n"
-	for k, v := range metadata {
+	for k, v := range forecast.Properties.Meta.Units {
 		metadataStr += fmt.Sprintf("- %s=%v\n", k, v)
 	}
 
-	// get the first timeseries
-	timeseries := data["properties"].(map[string]interface{})["timeseries"].([]interface{})[0]
-
 	// format the forecast as a string in the form of key=value\n
 	// timestamp is found in .time
-	// the actual forecast key value paris is found in.data.instant.details
+	// the actual forecast key value pairs are found in .data.instant.details
+	timeseries := forecast.Properties.Timeseries[0]
 	forecastStr := "\n#forecast:\n"
-	forecastStr += fmt.Sprintf("- timestamp=%s\n", timeseries.(map[string]interface{})["time"])
-	forecastdata := timeseries.(map[string]interface{})["data"].(map[string]interface{})["instant"].(map[string]interface{})["details"].(map[string]interface{})
-	for k, v := range forecastdata {
+	forecastStr += fmt.Sprintf("- timestamp=%s\n", timeseries.Time)
+	for k, v := range timeseries.Data.Instant.Details {
 		forecastStr += fmt.Sprintf("- %s=%v\n", k, v)
 	}
 
-	return metadataStr + forecastStr
+	return metadataStr + forecastStr, nil
+}
+
+type forecastResponse struct {
+	Properties struct {
+		Meta struct {
+			Units map[string]any `json:"units"`
+		} `json:"meta"`
+		Timeseries []struct {
+			Time string `json:"time"`
+			Data struct {
+				Instant struct {
+					Details map[string]any `json:"details"`
+				} `json:"instant"`
+			} `json:"data"`
+		} `json:"timeseries"`
+	} `json:"properties"`
 }
@@ -6,6 +6,7 @@ import (
 	"github.com/emilkje/go-openai-toolkit/toolkit"
 	"github.com/sashabaranov/go-openai"
 	"github.com/sashabaranov/go-openai/jsonschema"
+	"time"
 )
 
 func (w *WeatherTool) Definition() openai.FunctionDefinition {
@@ -32,3 +33,11 @@ func (w *WeatherTool) Definition() openai.FunctionDefinition {
 func NewWeatherTool() *WeatherTool {
 	return &WeatherTool{&toolkit.ToolArgs[WeatherToolArgs]{}}
 }
+
+func (w *WeatherTool) NewInstance() toolkit.Definable {
+	return NewWeatherTool()
+}
+
+func (w *WeatherTool) Timeout() time.Duration {
+	return 10 * time.Second
+}
@@ -0,0 +1,117 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/toolkit"
+)
+
+var ErrSuspended = errors.New("conversation suspended until tool calls are approved")
+
+type ApprovalAction int
+
+const (
+	// Deny skips the tool call and reports the reason to the model. It is the zero value,
+	// so an empty Decision never executes a tool by accident.
+	Deny ApprovalAction = iota
+	// Approve executes the tool call.
+	Approve
+	// Suspend stops the conversation with a *SuspendedError until a decision arrives, see Runtime.Resume.
+	Suspend
+)
+
+type Decision struct {
+	Action ApprovalAction `json:"action"`
+	// Reason is reported to the model when the tool call is denied.
+	Reason string `json:"reason,omitempty"`
+}
+
+func Approved() Decision {
+	return Decision{Action: Approve}
+}
+
+func Denied(reason string) Decision {
+	return Decision{Action: Deny, Reason: reason}
+}
+
+func Suspended() Decision {
+	return Decision{Action: Suspend}
+}
+
+// Approver decides whether a tool call that requires approval may be executed.
+// Returning an error stops the conversation with that error.
+type Approver func(ctx context.Context, toolCall openai.ToolCall) (Decision, error)
+
+// SuspendedError is returned when the approver suspended the conversation.
+// The other tool calls of the round have been executed and their results are part of Messages.
+// The conversation can be continued with Runtime.Resume or Runtime.ResumeState.
+type SuspendedError struct {
+	Messages []openai.ChatCompletionMessage
+	Pending  []openai.ToolCall
+	State    *State
+}
+
+func (e *SuspendedError) Error() string {
+	return fmt.Sprintf("%v: %d tool calls pending", ErrSuspended, len(e.Pending))
+}
+
+func (e *SuspendedError) Unwrap() error {
+	return ErrSuspended
+}
+
+// WithApprover sets the approver asked before tools that require approval are executed.
+// Without an approver such tool calls are denied.
+func WithApprover(approver Approver) Option {
+	return optionFunc(func(r *Runtime) {
+		r.approver = approver
+	})
+}
+
+// WithApprovalRequired requires approval for the named tools, in addition to tools implementing toolkit.Confirmable.
+func WithApprovalRequired(toolNames ...string) Option {
+	return optionFunc(func(r *Runtime) {
+		if r.approvalRequired == nil {
+			r.approvalRequired = make(map[string]bool)
+		}
+		for _, name := range toolNames {
+			r.approvalRequired[name] = true
+		}
+	})
+}
+
+// Resume continues a conversation that was suspended, or that ends with tool calls without results.
+// The pending tool calls are decided by decisions, keyed by tool call ID, and by the approver for the
+// tool calls without a decision. The conversation loop then continues as in Run.
+func (r *Runtime) Resume(ctx context.Context, messages []openai.ChatCompletionMessage, decisions map[string]Decision, opts ...RequestOption) (*ChatResult, error) {
+	return r.ResumeState(ctx, newState(messages), decisions, opts...)
+}
+
+func (r *Runtime) requiresApproval(toolName string) bool {
+	if r.approvalRequired[toolName] {
+		return true
+	}
+	tool, ok := r.toolkit.Lookup(toolName)
+	if !ok {
+		return false
+	}
+	confirmable, ok := tool.(toolkit.Confirmable)
+	return ok && confirmable.RequiresConfirmation()
+}
+
+// decide returns the decision for a tool call. Tool calls that do not require approval are always approved.
+func (r *Runtime) decide(ctx context.Context, state *State, toolCall openai.ToolCall) (Decision, error) {
+	if !r.requiresApproval(toolCall.Function.Name) {
+		return Approved(), nil
+	}
+	if decision, ok := state.Decisions[toolCall.ID]; ok {
+		return decision, nil
+	}
+	if r.approver == nil {
+		return Denied("no approver is configured"), nil
+	}
+	return r.approver(ctx, toolCall)
+}
@@ -0,0 +1,81 @@
+package runtime_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+	"github.com/emilkje/go-openai-toolkit/toolkit"
+)
+
+// confirmTool is an echo tool that declares it requires confirmation, like tools generated with +tool:confirm.
+type confirmTool struct {
+	*echoTool
+}
+
+func (t *confirmTool) RequiresConfirmation() bool {
+	return true
+}
+
+var _ toolkit.Confirmable = (*confirmTool)(nil)
+
+func TestApprovalDenied(t *testing.T) {
+	tests := []struct {
+		name       string
+		tool       func(echo *echoTool) toolkit.Callable
+		opts       []runtime.Option
+		wantReason string
+	}{
+		{
+			name: "denied by the approver",
+			tool: func(echo *echoTool) toolkit.Callable { return echo },
+			opts: []runtime.Option{
+				runtime.WithApprovalRequired("echo"),
+				runtime.WithApprover(func(context.Context, openai.ToolCall) (runtime.Decision, error) {
+					return runtime.Denied("the user declined"), nil
+				}),
+			},
+			wantReason: "the user declined",
+		},
+		{
+			name:       "no approver",
+			tool:       func(echo *echoTool) toolkit.Callable { return echo },
+			opts:       []runtime.Option{runtime.WithApprovalRequired("echo")},
+			wantReason: "no approver is configured",
+		},
+		{
+			name:       "confirmable tool without an approver",
+			tool:       func(echo *echoTool) toolkit.Callable { return &confirmTool{echo} },
+			wantReason: "no approver is configured",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := newEchoTool()
+			fake := runtimetest.NewFakeClient(runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"a"}`)), runtimetest.Reply("done"))
+			r := runtime.NewRuntime(fake, newToolkit(tt.tool(echo)), tt.opts...)
+
+			result, err := r.Run(context.Background(), userMessage("hi"))
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := echo.calls.Load(); got != 0 {
+				t.Errorf("tool executions = %d, want none", got)
+			}
+			if execution := result.ToolExecutions[0]; execution.Err == nil || execution.Err.Kind != runtime.ToolErrorDenied {
+				t.Errorf("execution error = %v, want kind %s", execution.Err, runtime.ToolErrorDenied)
+			}
+
+			// the model learns why the call was denied
+			followUp := fake.Requests()[1].Messages
+			tool := followUp[len(followUp)-1]
+			if tool.Role != openai.ChatMessageRoleTool || tool.ToolCallID != "c1" || !strings.Contains(tool.Content, tt.wantReason) {
+				t.Errorf("tool message = %+v, want the reason %q", tool, tt.wantReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatClient is the part of a chat completion API the runtime depends on.
+// *openai.Client satisfies it, as can mocks, proxies or other OpenAI-compatible backends.
+type ChatClient interface {
+	CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+}
+
+var _ ChatClient = (*openai.Client)(nil)
+
+// ChatStream is a stream of chat completion chunks. Recv returns io.EOF once the stream is done.
+type ChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close()
+}
+
+// StreamingChatClient is implemented by clients that can stream chat completions.
+// *openai.Client is adapted automatically, other clients have to implement it to be streamed.
+// Clients that only implement ChatClient are still usable with ProcessChatStream,
+// every round is then reported as a single content delta.
+type StreamingChatClient interface {
+	ChatClient
+	CreateChatStream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error)
+}
+
+// openaiStreamer matches the streaming method of *openai.Client.
+type openaiStreamer interface {
+	CreateChatCompletionStream(ctx context.Context, request openai.ChatCompletionRequest) (*openai.ChatCompletionStream, error)
+}
+
+type openaiStreamingClient struct {
+	ChatClient
+	streamer openaiStreamer
+}
+
+func (c openaiStreamingClient) CreateChatStream(ctx context.Context, request openai.ChatCompletionRequest) (ChatStream, error) {
+	return c.streamer.CreateChatCompletionStream(ctx, request)
+}
+
+// asStreamingClient returns a StreamingChatClient for client if it supports streaming.
+func asStreamingClient(client ChatClient) (StreamingChatClient, bool) {
+	switch c := client.(type) {
+	case StreamingChatClient:
+		return c, true
+	case openaiStreamer:
+		return openaiStreamingClient{ChatClient: client, streamer: c}, true
+	default:
+		return nil, false
+	}
+}
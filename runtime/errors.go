@@ -0,0 +1,131 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/toolkit"
+)
+
+var (
+	ErrMaxIterations = errors.New("maximum number of rounds reached")
+	ErrMaxToolCalls  = errors.New("maximum number of tool calls reached")
+	ErrToolLoop      = errors.New("repeated identical tool call detected")
+	ErrNoChoices     = errors.New("chat completion returned no choices")
+)
+
+// LoopError is returned when the conversation loop is stopped by one of the configured limits.
+// It wraps ErrMaxIterations, ErrMaxToolCalls or ErrToolLoop and carries the transcript so far.
+type LoopError struct {
+	Err       error
+	Messages  []openai.ChatCompletionMessage
+	Rounds    int
+	ToolCalls int
+}
+
+func (e *LoopError) Error() string {
+	return fmt.Sprintf("%v (after %d rounds and %d tool calls)", e.Err, e.Rounds, e.ToolCalls)
+}
+
+func (e *LoopError) Unwrap() error {
+	return e.Err
+}
+
+type ToolErrorKind string
+
+const (
+	ToolErrorNotFound         ToolErrorKind = "not_found"
+	ToolErrorInvalidArguments ToolErrorKind = "invalid_arguments"
+	ToolErrorExecutionFailed  ToolErrorKind = "execution_failed"
+	ToolErrorPanic            ToolErrorKind = "panic"
+	ToolErrorTimeout          ToolErrorKind = "timeout"
+	ToolErrorBusy             ToolErrorKind = "busy"
+	ToolErrorDenied           ToolErrorKind = "denied"
+)
+
+// ToolError describes a failed tool call. It is reported to the model through the ToolErrorFormatter.
+type ToolError struct {
+	Kind       ToolErrorKind
+	Tool       string
+	ToolCallID string
+	Err        error
+}
+
+func (e *ToolError) Error() string {
+	return fmt.Sprintf("tool %s (call %s) failed with %s: %v", e.Tool, e.ToolCallID, e.Kind, e.Err)
+}
+
+func (e *ToolError) Unwrap() error {
+	return e.Err
+}
+
+func newToolError(toolCall openai.ToolCall, err error) *ToolError {
+	var toolErr *ToolError
+	if errors.As(err, &toolErr) {
+		return toolErr
+	}
+
+	kind := ToolErrorExecutionFailed
+	var argErr *toolkit.ArgumentError
+	var panicErr *PanicError
+	switch {
+	case errors.As(err, &panicErr):
+		kind = ToolErrorPanic
+	case errors.Is(err, toolkit.ErrToolNotFound):
+		kind = ToolErrorNotFound
+	case errors.As(err, &argErr):
+		kind = ToolErrorInvalidArguments
+	case errors.Is(err, toolkit.ErrToolBusy):
+		kind = ToolErrorBusy
+	case errors.Is(err, context.DeadlineExceeded):
+		kind = ToolErrorTimeout
+	}
+
+	return &ToolError{
+		Kind:       kind,
+		Tool:       toolCall.Function.Name,
+		ToolCallID: toolCall.ID,
+		Err:        err,
+	}
+}
+
+// PanicError is the cause of a ToolError of kind ToolErrorPanic.
+type PanicError struct {
+	Value any
+	Stack []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// ToolErrorFormatter renders the content of the tool message the model receives for a failed tool call.
+type ToolErrorFormatter func(err *ToolError) string
+
+// DefaultToolErrorFormatter describes the failure without exposing details of panics.
+func DefaultToolErrorFormatter(err *ToolError) string {
+	switch err.Kind {
+	case ToolErrorNotFound:
+		return fmt.Sprintf("error: tool %s does not exist", err.Tool)
+	case ToolErrorInvalidArguments:
+		cause := err.Err
+		var argErr *toolkit.ArgumentError
+		if errors.As(cause, &argErr) {
+			cause = argErr.Err
+		}
+		return fmt.Sprintf("error: invalid arguments for tool %s: %v", err.Tool, cause)
+	case ToolErrorPanic:
+		return fmt.Sprintf("error: tool %s failed unexpectedly", err.Tool)
+	case ToolErrorTimeout:
+		return fmt.Sprintf("error: tool %s timed out", err.Tool)
+	case ToolErrorBusy:
+		return fmt.Sprintf("error: tool %s is busy, try again later", err.Tool)
+	case ToolErrorDenied:
+		return fmt.Sprintf("error: the call of tool %s was denied: %v", err.Tool, err.Err)
+	default:
+		return fmt.Sprintf("error executing tool %s: %v", err.Tool, err.Err)
+	}
+}
@@ -0,0 +1,61 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// CompletionHandler sends a single chat completion request to the model.
+type CompletionHandler func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+
+// CompletionMiddleware wraps every chat completion request, including retries.
+// It can inspect or rewrite the request and the response, or short-circuit the call by not calling next.
+type CompletionMiddleware func(next CompletionHandler) CompletionHandler
+
+// ToolHandler executes a single tool call and returns its result.
+type ToolHandler func(ctx context.Context, toolCall openai.ToolCall) (string, error)
+
+// ToolMiddleware wraps every execution of a tool call, including retries.
+// It can rewrite the arguments of the call or its result, or short-circuit the call by not calling next.
+type ToolMiddleware func(next ToolHandler) ToolHandler
+
+// CompletionHook is called once a conversation has finished, successfully or not.
+type CompletionHook func(ctx context.Context, result *ChatResult, err error)
+
+// WithCompletionMiddleware adds middleware around chat completion requests.
+// The first middleware added is the outermost one.
+func WithCompletionMiddleware(middleware ...CompletionMiddleware) Option {
+	return optionFunc(func(r *Runtime) {
+		r.completionMiddleware = append(r.completionMiddleware, middleware...)
+	})
+}
+
+// WithToolMiddleware adds middleware around tool calls.
+// The first middleware added is the outermost one.
+func WithToolMiddleware(middleware ...ToolMiddleware) Option {
+	return optionFunc(func(r *Runtime) {
+		r.toolMiddleware = append(r.toolMiddleware, middleware...)
+	})
+}
+
+// WithCompletionHook registers a hook that is called whenever a conversation has finished.
+func WithCompletionHook(hook CompletionHook) Option {
+	return optionFunc(func(r *Runtime) {
+		r.completionHooks = append(r.completionHooks, hook)
+	})
+}
+
+func (r *Runtime) wrapCompletion(handler CompletionHandler) CompletionHandler {
+	for i := len(r.completionMiddleware) - 1; i >= 0; i-- {
+		handler = r.completionMiddleware[i](handler)
+	}
+	return handler
+}
+
+func (r *Runtime) wrapTool(handler ToolHandler) ToolHandler {
+	for i := len(r.toolMiddleware) - 1; i >= 0; i-- {
+		handler = r.toolMiddleware[i](handler)
+	}
+	return handler
+}
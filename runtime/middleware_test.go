@@ -0,0 +1,211 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+func TestCompletionMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) runtime.CompletionMiddleware {
+		return func(next runtime.CompletionHandler) runtime.CompletionHandler {
+			return func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+				calls = append(calls, name+" before")
+				response, err := next(ctx, req)
+				calls = append(calls, name+" after")
+				return response, err
+			}
+		}
+	}
+	rewrite := func(next runtime.CompletionHandler) runtime.CompletionHandler {
+		return func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+			req.User = "rewritten"
+			response, err := next(ctx, req)
+			if err == nil {
+				response.Choices[0].Message.Content += "!"
+			}
+			return response, err
+		}
+	}
+	fake := runtimetest.NewFakeClient(runtimetest.Reply("done"))
+	r := runtime.NewRuntime(fake, newTestToolkit(),
+		runtime.WithCompletionMiddleware(trace("first"), trace("second")),
+		runtime.WithCompletionMiddleware(rewrite),
+	)
+
+	result, err := r.Run(context.Background(), userMessage("hi"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if want := []string{"first before", "second before", "second after", "first after"}; !slices.Equal(calls, want) {
+		t.Errorf("middleware calls = %v, want %v", calls, want)
+	}
+	if got := fake.Requests()[0].User; got != "rewritten" {
+		t.Errorf("request user = %q, want the rewritten request", got)
+	}
+	if got := result.FinalMessage.Content; got != "done!" {
+		t.Errorf("final message = %q, want the rewritten response", got)
+	}
+}
+
+func TestCompletionMiddlewareShortCircuit(t *testing.T) {
+	cached := func(runtime.CompletionHandler) runtime.CompletionHandler {
+		return func(context.Context, openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+			return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{
+				Message:      openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: "cached"},
+				FinishReason: openai.FinishReasonStop,
+			}}}, nil
+		}
+	}
+
+	t.Run("run", func(t *testing.T) {
+		fake := runtimetest.NewFakeClient()
+		r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithCompletionMiddleware(cached))
+
+		result, err := r.Run(context.Background(), userMessage("hi"))
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if result.FinalMessage.Content != "cached" || len(fake.Requests()) != 0 {
+			t.Errorf("final message = %q after %d requests, want the cached reply without a request", result.FinalMessage.Content, len(fake.Requests()))
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		fake := runtimetest.NewFakeClient()
+		r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithCompletionMiddleware(cached))
+
+		var content string
+		var messages []string
+		_, err := r.RunStream(context.Background(), userMessage("hi"), func(event runtime.StreamEvent) {
+			switch event.Type {
+			case runtime.StreamEventContent:
+				content += event.Content
+			case runtime.StreamEventMessage:
+				messages = append(messages, event.Message.Content)
+			}
+		})
+		if err != nil {
+			t.Fatalf("RunStream() error = %v", err)
+		}
+		// a response that never reached the client is still reported to the handler
+		if content != "cached" || !slices.Equal(messages, []string{"cached"}) {
+			t.Errorf("streamed content = %q and messages = %q, want the cached reply", content, messages)
+		}
+		if len(fake.Requests()) != 0 {
+			t.Errorf("requests = %d, want none", len(fake.Requests()))
+		}
+	})
+}
+
+func TestToolMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		middleware runtime.ToolMiddleware
+		wantResult string
+		wantCalls  int32
+	}{
+		{
+			name: "rewrite arguments",
+			middleware: func(next runtime.ToolHandler) runtime.ToolHandler {
+				return func(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+					toolCall.Function.Arguments = `{"text":"rewritten"}`
+					return next(ctx, toolCall)
+				}
+			},
+			wantResult: "echo:rewritten",
+			wantCalls:  1,
+		},
+		{
+			name: "rewrite result",
+			middleware: func(next runtime.ToolHandler) runtime.ToolHandler {
+				return func(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+					result, err := next(ctx, toolCall)
+					return "[" + result + "]", err
+				}
+			},
+			wantResult: "[echo:a]",
+			wantCalls:  1,
+		},
+		{
+			name: "short-circuit",
+			middleware: func(runtime.ToolHandler) runtime.ToolHandler {
+				return func(context.Context, openai.ToolCall) (string, error) {
+					return "blocked", nil
+				}
+			},
+			wantResult: "blocked",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := newEchoTool()
+			var calls []string
+			outer := func(next runtime.ToolHandler) runtime.ToolHandler {
+				return func(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+					calls = append(calls, "outer")
+					return next(ctx, toolCall)
+				}
+			}
+			fake := runtimetest.NewFakeClient(runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"a"}`)), runtimetest.Reply("done"))
+			r := runtime.NewRuntime(fake, newToolkit(echo), runtime.WithToolMiddleware(outer, tt.middleware))
+
+			result, err := r.Run(context.Background(), userMessage("hi"))
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := toolResults(result.Messages); !slices.Equal(got, []string{"c1=" + tt.wantResult}) {
+				t.Errorf("tool results = %v, want c1=%s", got, tt.wantResult)
+			}
+			if got := echo.calls.Load(); got != tt.wantCalls {
+				t.Errorf("tool executions = %d, want %d", got, tt.wantCalls)
+			}
+			// the first middleware added is the outermost one, so it runs even if the inner one short-circuits
+			if !slices.Equal(calls, []string{"outer"}) {
+				t.Errorf("outer middleware calls = %v, want one", calls)
+			}
+		})
+	}
+}
+
+func TestCompletionHooks(t *testing.T) {
+	failure := errors.New("model unavailable")
+	tests := []struct {
+		name      string
+		responses []runtimetest.Response
+		wantErr   error
+	}{
+		{name: "success", responses: []runtimetest.Response{runtimetest.Reply("done")}},
+		{name: "failure", responses: []runtimetest.Response{runtimetest.Fail(failure)}, wantErr: failure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hooked []error
+			var hookedResult *runtime.ChatResult
+			hook := func(_ context.Context, result *runtime.ChatResult, err error) {
+				hooked = append(hooked, err)
+				hookedResult = result
+			}
+			r := runtime.NewRuntime(runtimetest.NewFakeClient(tt.responses...), newTestToolkit(),
+				runtime.WithCompletionHook(hook), runtime.WithCompletionHook(hook))
+
+			_, err := r.Run(context.Background(), userMessage("hi"))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if len(hooked) != 2 || !errors.Is(hooked[0], tt.wantErr) || !errors.Is(hooked[1], tt.wantErr) {
+				t.Errorf("hooks called with %v, want both hooks called once with %v", hooked, tt.wantErr)
+			}
+			if hookedResult == nil || len(hookedResult.Messages) == 0 {
+				t.Errorf("hook result = %+v, want the transcript", hookedResult)
+			}
+		})
+	}
+}
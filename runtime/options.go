@@ -0,0 +1,243 @@
+package runtime
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Option configures a Runtime.
+type Option interface {
+	apply(r *Runtime)
+}
+
+type optionFunc func(r *Runtime)
+
+func (f optionFunc) apply(r *Runtime) {
+	f(r)
+}
+
+// RequestOption modifies the chat completion request sent to the model.
+// It can be passed to NewRuntime to set a default for every call,
+// or to ProcessChat and ProcessChatContext to override the default for a single call.
+type RequestOption func(req *openai.ChatCompletionRequest)
+
+func (o RequestOption) apply(r *Runtime) {
+	o(&r.request)
+}
+
+// WithRequestTemplate replaces the request used as a starting point for every chat completion.
+// Messages and Tools of the template are ignored, they are always provided by the runtime.
+// The template replaces the request options passed to NewRuntime before it, so pass it first.
+func WithRequestTemplate(template openai.ChatCompletionRequest) Option {
+	return optionFunc(func(r *Runtime) {
+		r.request = template
+	})
+}
+
+// WithModel sets the model, or the deployment name with Azure OpenAI.
+func WithModel(model string) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.Model = model
+	}
+}
+
+// WithTemperature sets the sampling temperature.
+// Note that a temperature of 0 is omitted from the request and the API default is used instead.
+func WithTemperature(temperature float32) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.Temperature = temperature
+	}
+}
+
+// WithTopP sets nucleus sampling, the model only considers the tokens within the top topP probability mass.
+func WithTopP(topP float32) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.TopP = topP
+	}
+}
+
+// WithMaxTokens limits the number of tokens the model may generate in a single round.
+func WithMaxTokens(maxTokens int) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.MaxTokens = maxTokens
+	}
+}
+
+// WithSeed asks the model to sample deterministically, so repeated requests return the same result where possible.
+func WithSeed(seed int) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.Seed = &seed
+	}
+}
+
+// WithUser identifies the end user to the API for abuse monitoring.
+func WithUser(user string) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.User = user
+	}
+}
+
+// WithStop sets up to 4 sequences at which the model stops generating.
+func WithStop(stop ...string) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.Stop = stop
+	}
+}
+
+// WithPresencePenalty penalizes tokens that already appeared, between -2.0 and 2.0.
+func WithPresencePenalty(penalty float32) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.PresencePenalty = penalty
+	}
+}
+
+// WithFrequencyPenalty penalizes tokens by how often they already appeared, between -2.0 and 2.0.
+func WithFrequencyPenalty(penalty float32) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.FrequencyPenalty = penalty
+	}
+}
+
+// WithLogitBias adjusts the likelihood of the given token IDs, between -100 and 100.
+func WithLogitBias(bias map[string]int) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.LogitBias = bias
+	}
+}
+
+// WithLogProbs returns the log probabilities of the generated tokens and of the topLogProbs most likely alternatives.
+func WithLogProbs(topLogProbs int) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.LogProbs = true
+		req.TopLogProbs = topLogProbs
+	}
+}
+
+// WithResponseFormat sets the format of the response, e.g. openai.ChatCompletionResponseFormatTypeJSONObject.
+func WithResponseFormat(format openai.ChatCompletionResponseFormatType) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: format}
+	}
+}
+
+// WithToolChoice controls which tool, if any, the model is forced to call.
+// It accepts either a string ("none", "auto") or an openai.ToolChoice.
+func WithToolChoice(choice any) RequestOption {
+	return func(req *openai.ChatCompletionRequest) {
+		req.ToolChoice = choice
+	}
+}
+
+// WithMaxIterations limits the number of chat completion rounds in a single conversation.
+// A value of 0 disables the limit.
+func WithMaxIterations(n int) Option {
+	return optionFunc(func(r *Runtime) {
+		r.maxIterations = n
+	})
+}
+
+// WithMaxToolCalls limits the total number of tool calls in a single conversation.
+// A value of 0 disables the limit.
+func WithMaxToolCalls(n int) Option {
+	return optionFunc(func(r *Runtime) {
+		r.maxToolCalls = n
+	})
+}
+
+// WithMaxRepeatedToolCalls stops the conversation when the model calls the same tool
+// with identical arguments more than n times. A value of 0 disables loop detection.
+func WithMaxRepeatedToolCalls(n int) Option {
+	return optionFunc(func(r *Runtime) {
+		r.maxRepeatedCalls = n
+	})
+}
+
+// WithParallelToolCalls executes up to limit tool calls of a single assistant message concurrently.
+// Tool results are always appended in the order of the original tool calls.
+// A limit of 1 or less executes the tool calls sequentially.
+func WithParallelToolCalls(limit int) Option {
+	return optionFunc(func(r *Runtime) {
+		r.parallelToolCalls = limit
+	})
+}
+
+// WithToolErrorFormatter controls what the model sees when a tool call fails.
+func WithToolErrorFormatter(formatter ToolErrorFormatter) Option {
+	return optionFunc(func(r *Runtime) {
+		r.toolErrorFormatter = formatter
+	})
+}
+
+// WithToolErrorPolicy sets the policy applied to failed tool calls.
+func WithToolErrorPolicy(policy ToolErrorPolicy) Option {
+	return optionFunc(func(r *Runtime) {
+		r.toolErrorPolicy = policy
+	})
+}
+
+// WithToolErrorPolicyFor overrides the policy applied to failed calls of the named tool.
+func WithToolErrorPolicyFor(toolName string, policy ToolErrorPolicy) Option {
+	return optionFunc(func(r *Runtime) {
+		if r.toolErrorPolicies == nil {
+			r.toolErrorPolicies = make(map[string]ToolErrorPolicy)
+		}
+		r.toolErrorPolicies[toolName] = policy
+	})
+}
+
+// WithLogger sets the logger used by the runtime. It defaults to slog.Default().
+func WithLogger(logger *slog.Logger) Option {
+	return optionFunc(func(r *Runtime) {
+		r.logger = logger
+	})
+}
+
+// WithPanicHandler registers a handler that is called whenever a tool panics,
+// e.g. to report the panic to an error tracker.
+func WithPanicHandler(handler PanicHandler) Option {
+	return optionFunc(func(r *Runtime) {
+		r.panicHandler = handler
+	})
+}
+
+// WithToolTimeout sets the default timeout of a single tool call. A value of 0 disables the timeout.
+// Tools implementing toolkit.Timed use their own timeout instead.
+func WithToolTimeout(timeout time.Duration) Option {
+	return optionFunc(func(r *Runtime) {
+		r.toolTimeout = timeout
+	})
+}
+
+// WithToolTimeoutFor overrides the timeout of a single call of the named tool.
+func WithToolTimeoutFor(toolName string, timeout time.Duration) Option {
+	return optionFunc(func(r *Runtime) {
+		if r.toolTimeouts == nil {
+			r.toolTimeouts = make(map[string]time.Duration)
+		}
+		r.toolTimeouts[toolName] = timeout
+	})
+}
+
+// WithRetryPolicy retries failed chat completion calls according to policy, see DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return optionFunc(func(r *Runtime) {
+		r.retryPolicy = policy
+	})
+}
+
+// WithRateLimiter throttles every chat completion request, including retries, with limiter.
+// The same limiter can be shared by several runtimes.
+func WithRateLimiter(limiter *RateLimiter) Option {
+	return optionFunc(func(r *Runtime) {
+		r.rateLimiter = limiter
+	})
+}
+
+// WithPriceTable sets the prices used to compute the cost of every conversation.
+func WithPriceTable(prices PriceTable) Option {
+	return optionFunc(func(r *Runtime) {
+		r.prices = prices
+	})
+}
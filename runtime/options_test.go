@@ -0,0 +1,114 @@
+package runtime_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+func TestRequestOptions(t *testing.T) {
+	template := openai.ChatCompletionRequest{
+		Model:    "template-model",
+		User:     "template-user",
+		Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "ignored"}},
+	}
+	tests := []struct {
+		name            string
+		opts            []runtime.Option
+		callOpts        []runtime.RequestOption
+		wantModel       string
+		wantUser        string
+		wantTemperature float32
+	}{
+		{
+			name:      "built-in default",
+			wantModel: openai.GPT4TurboPreview,
+		},
+		{
+			name:            "runtime defaults",
+			opts:            []runtime.Option{runtime.WithModel("gpt-4"), runtime.WithUser("alice"), runtime.WithTemperature(0.5)},
+			wantModel:       "gpt-4",
+			wantUser:        "alice",
+			wantTemperature: 0.5,
+		},
+		{
+			name:            "call options override the defaults",
+			opts:            []runtime.Option{runtime.WithModel("gpt-4"), runtime.WithUser("alice"), runtime.WithTemperature(0.5)},
+			callOpts:        []runtime.RequestOption{runtime.WithModel("gpt-3.5-turbo"), runtime.WithTemperature(0.1)},
+			wantModel:       "gpt-3.5-turbo",
+			wantUser:        "alice",
+			wantTemperature: 0.1,
+		},
+		{
+			name:      "options after the template modify it",
+			opts:      []runtime.Option{runtime.WithRequestTemplate(template), runtime.WithModel("gpt-4")},
+			wantModel: "gpt-4",
+			wantUser:  "template-user",
+		},
+		{
+			name:      "the template replaces options before it",
+			opts:      []runtime.Option{runtime.WithModel("gpt-4"), runtime.WithUser("alice"), runtime.WithRequestTemplate(template)},
+			wantModel: "template-model",
+			wantUser:  "template-user",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := runtimetest.NewFakeClient(runtimetest.Reply("done"))
+			r := runtime.NewRuntime(fake, newTestToolkit(), tt.opts...)
+
+			if _, err := r.Run(context.Background(), userMessage("hi"), tt.callOpts...); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			req := fake.Requests()[0]
+			if req.Model != tt.wantModel || req.User != tt.wantUser || req.Temperature != tt.wantTemperature {
+				t.Errorf("request model = %q, user = %q, temperature = %v, want %q, %q, %v",
+					req.Model, req.User, req.Temperature, tt.wantModel, tt.wantUser, tt.wantTemperature)
+			}
+			if len(req.Messages) != 1 || req.Messages[0].Content != "hi" || len(req.Tools) == 0 {
+				t.Errorf("request messages = %+v with %d tools, want the conversation and the tools of the toolkit", req.Messages, len(req.Tools))
+			}
+		})
+	}
+}
+
+func TestRequestOptionsOnlyApplyToTheirCall(t *testing.T) {
+	fake := runtimetest.NewFakeClient(runtimetest.Reply("first"), runtimetest.Reply("second"))
+	r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithModel("gpt-4"))
+
+	if _, err := r.Run(context.Background(), userMessage("hi"), runtime.WithModel("gpt-3.5-turbo")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, err := r.Run(context.Background(), userMessage("hi")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if first, second := fake.Requests()[0].Model, fake.Requests()[1].Model; first != "gpt-3.5-turbo" || second != "gpt-4" {
+		t.Errorf("request models = %q, %q, want the call option only for the first call", first, second)
+	}
+}
+
+func TestRequestOptionFields(t *testing.T) {
+	req := openai.ChatCompletionRequest{}
+	for _, opt := range []runtime.RequestOption{
+		runtime.WithTopP(0.9),
+		runtime.WithMaxTokens(100),
+		runtime.WithSeed(42),
+		runtime.WithStop("END"),
+		runtime.WithPresencePenalty(0.5),
+		runtime.WithFrequencyPenalty(0.25),
+		runtime.WithLogProbs(3),
+		runtime.WithResponseFormat(openai.ChatCompletionResponseFormatTypeJSONObject),
+	} {
+		opt(&req)
+	}
+
+	if req.TopP != 0.9 || req.MaxTokens != 100 || req.Seed == nil || *req.Seed != 42 || len(req.Stop) != 1 ||
+		req.PresencePenalty != 0.5 || req.FrequencyPenalty != 0.25 || !req.LogProbs || req.TopLogProbs != 3 ||
+		req.ResponseFormat == nil || req.ResponseFormat.Type != openai.ChatCompletionResponseFormatTypeJSONObject {
+		t.Errorf("request = %+v, want every option applied", req)
+	}
+}
@@ -0,0 +1,272 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"unicode/utf8"
+)
+
+// ErrOutputNotFound is returned by an output store for an unknown reference.
+var ErrOutputNotFound = errors.New("tool output not found")
+
+// Bounds of the output store a Runtime uses by default.
+const (
+	DefaultMaxStoredOutputs     = 100
+	DefaultMaxStoredOutputBytes = 16 << 20
+)
+
+// DefaultOutputSummaryPrompt instructs the model how to summarize an oversized tool result.
+const DefaultOutputSummaryPrompt = "You summarize the output of a tool for an assistant that called it. " +
+	"Keep every value the assistant may need to answer the user, in particular numbers, names and identifiers. " +
+	"Answer with the summary only."
+
+// TruncationMode selects how a tool result exceeding its OutputLimit is shortened.
+type TruncationMode int
+
+const (
+	// KeepHead keeps the beginning of the output.
+	KeepHead TruncationMode = iota
+	// KeepTail keeps the end of the output.
+	KeepTail
+	// KeepHeadAndTail keeps the beginning and the end of the output.
+	KeepHeadAndTail
+	// SummarizeOutput replaces the output with a summary written by the model,
+	// falling back to KeepHead if the summary fails.
+	SummarizeOutput
+)
+
+// OutputLimit limits the size of the tool results the model receives.
+type OutputLimit struct {
+	// MaxTokens is the estimated number of tokens a tool result may take, 0 disables the limit.
+	MaxTokens int
+	Mode      TruncationMode
+	// Model summarizes the output with SummarizeOutput, the model of the conversation is used if empty.
+	Model string
+}
+
+// OutputStore keeps the full output of tool results that were shortened. Outputs are keyed by a reference
+// made of the conversation ID, the index of the tool message and the tool call ID, see ToolExecution.OutputRef.
+type OutputStore interface {
+	Put(ctx context.Context, ref string, output string) error
+	Get(ctx context.Context, ref string) (string, error)
+}
+
+// WithOutputLimit limits the size of every tool result.
+func WithOutputLimit(limit OutputLimit) Option {
+	return optionFunc(func(r *Runtime) {
+		r.outputLimit = limit
+	})
+}
+
+// WithOutputLimitFor overrides the output limit of the named tool.
+func WithOutputLimitFor(toolName string, limit OutputLimit) Option {
+	return optionFunc(func(r *Runtime) {
+		if r.outputLimits == nil {
+			r.outputLimits = make(map[string]OutputLimit)
+		}
+		r.outputLimits[toolName] = limit
+	})
+}
+
+// WithOutputStore keeps the full output of shortened tool results in store instead of in a bounded MemoryOutputStore.
+// With a nil store the full output of a shortened tool result is discarded.
+func WithOutputStore(store OutputStore) Option {
+	return optionFunc(func(r *Runtime) {
+		r.outputStore = store
+	})
+}
+
+// ToolOutput returns the full output of a shortened tool result by its ToolExecution.OutputRef.
+func (r *Runtime) ToolOutput(ctx context.Context, ref string) (string, error) {
+	if r.outputStore == nil {
+		return "", fmt.Errorf("%w: %s", ErrOutputNotFound, ref)
+	}
+	return r.outputStore.Get(ctx, ref)
+}
+
+// MemoryOutputStore keeps a bounded number of tool outputs in memory, evicting the oldest first.
+type MemoryOutputStore struct {
+	mu         sync.RWMutex
+	maxOutputs int
+	maxBytes   int
+	size       int
+	outputs    map[string]string
+	order      []string
+}
+
+var _ OutputStore = (*MemoryOutputStore)(nil)
+
+// NewMemoryOutputStore keeps at most maxOutputs outputs taking at most maxBytes bytes together.
+// A maxBytes of 0 only limits the number of outputs.
+func NewMemoryOutputStore(maxOutputs, maxBytes int) *MemoryOutputStore {
+	return &MemoryOutputStore{maxOutputs: max(maxOutputs, 1), maxBytes: max(maxBytes, 0), outputs: make(map[string]string)}
+}
+
+// Put stores output, evicting the oldest outputs until the store is within its bounds again.
+// An output larger than the byte budget of the store is not stored.
+func (s *MemoryOutputStore) Put(_ context.Context, ref string, output string) error {
+	if s.maxBytes > 0 && len(output) > s.maxBytes {
+		return fmt.Errorf("tool output of %d bytes exceeds the store budget of %d bytes", len(output), s.maxBytes)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if previous, exists := s.outputs[ref]; exists {
+		s.size -= len(previous)
+	} else {
+		s.order = append(s.order, ref)
+	}
+	s.outputs[ref] = output
+	s.size += len(output)
+
+	for len(s.order) > s.maxOutputs || s.maxBytes > 0 && s.size > s.maxBytes {
+		s.size -= len(s.outputs[s.order[0]])
+		delete(s.outputs, s.order[0])
+		s.order = s.order[1:]
+	}
+	return nil
+}
+
+func (s *MemoryOutputStore) Get(_ context.Context, ref string) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	output, ok := s.outputs[ref]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrOutputNotFound, ref)
+	}
+	return output, nil
+}
+
+// FileOutputStore keeps every tool output in a file named after its reference.
+type FileOutputStore struct {
+	dir string
+}
+
+var _ OutputStore = (*FileOutputStore)(nil)
+
+// NewFileOutputStore stores tool outputs in dir, which is created if it does not exist.
+func NewFileOutputStore(dir string) (*FileOutputStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileOutputStore{dir: dir}, nil
+}
+
+func (s *FileOutputStore) Put(_ context.Context, ref string, output string) error {
+	path, err := s.path(ref)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(output), 0o644)
+}
+
+func (s *FileOutputStore) Get(_ context.Context, ref string) (string, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return "", err
+	}
+	output, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("%w: %s", ErrOutputNotFound, ref)
+	}
+	return string(output), err
+}
+
+func (s *FileOutputStore) path(ref string) (string, error) {
+	if !validFileName(ref) {
+		return "", fmt.Errorf("invalid tool output reference: %q", ref)
+	}
+	return filepath.Join(s.dir, ref+".txt"), nil
+}
+
+// limitOutput shortens the result of a successful tool call that exceeds the output limit of the tool.
+// The full output is kept in the output store, if any, and the result refers to it by its reference.
+func (r *Runtime) limitOutput(ctx context.Context, state *State, execution *ToolExecution, opts []RequestOption) {
+	limit, ok := r.outputLimits[execution.Tool]
+	if !ok {
+		limit = r.outputLimit
+	}
+	if limit.MaxTokens <= 0 || execution.Err != nil || estimateText(execution.Result) <= limit.MaxTokens {
+		return
+	}
+
+	output := execution.Result
+	maxChars := limit.MaxTokens * charsPerToken
+	var shortened string
+	switch limit.Mode {
+	case KeepTail:
+		kept := tail(output, maxChars)
+		shortened = fmt.Sprintf("[first %d of %d characters omitted]\n%s", len(output)-len(kept), len(output), kept)
+	case KeepHeadAndTail:
+		first, last := head(output, maxChars/2), tail(output, maxChars/2)
+		shortened = fmt.Sprintf("%s\n[%d of %d characters omitted]\n%s", first, len(output)-len(first)-len(last), len(output), last)
+	case SummarizeOutput:
+		summary, err := r.summarizeOutput(ctx, state, execution, limit, opts)
+		if err == nil {
+			shortened = fmt.Sprintf("Summary of the output, which has %d characters:\n%s", len(output), summary)
+			break
+		}
+		r.logger.Warn("summarizing tool output failed", "tool", execution.Tool, "err", err)
+		fallthrough
+	default:
+		kept := head(output, maxChars)
+		shortened = fmt.Sprintf("%s\n[last %d of %d characters omitted]", kept, len(output)-len(kept), len(output))
+	}
+
+	execution.Result = shortened
+	if r.outputStore == nil {
+		return
+	}
+
+	// tool call IDs are only unique within a response, the reference also names the conversation and the message
+	ref := fmt.Sprintf("%s-%d-%s", state.ID, len(state.Messages), execution.ToolCallID)
+	if err := r.outputStore.Put(ctx, ref, output); err != nil {
+		r.logger.Warn("storing tool output failed", "tool", execution.Tool, "err", err)
+		return
+	}
+	execution.Result += fmt.Sprintf("\n[the full output is stored as %q]", ref)
+	execution.OutputRef = ref
+}
+
+func (r *Runtime) summarizeOutput(ctx context.Context, state *State, execution *ToolExecution, limit OutputLimit, opts []RequestOption) (string, error) {
+	model := limit.Model
+	if model == "" {
+		model = r.newRequest(nil, opts).Model
+	}
+
+	output := execution.Result
+	// the output may not even fit into the context window of the summarizing model
+	if window := ContextWindow(model); window > 0 && estimateText(output) > window/2 {
+		output = head(output, window/2*charsPerToken)
+	}
+
+	content := fmt.Sprintf("Tool %s was called with the arguments %s and returned:\n%s", execution.Tool, execution.Arguments, output)
+	return r.complete(ctx, state, model, DefaultOutputSummaryPrompt, content, opts)
+}
+
+// head returns at most n bytes of the beginning of s, without splitting a character.
+func head(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// tail returns at most n bytes of the end of s, without splitting a character.
+func tail(s string, n int) string {
+	if n >= len(s) {
+		return s
+	}
+	from := len(s) - n
+	for from < len(s) && !utf8.RuneStart(s[from]) {
+		from++
+	}
+	return s[from:]
+}
@@ -0,0 +1,168 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+func TestMemoryOutputStoreBounds(t *testing.T) {
+	ctx := context.Background()
+	store := runtime.NewMemoryOutputStore(3, 10)
+
+	for _, put := range []struct{ ref, output string }{{"a", "1234"}, {"b", "1234"}, {"c", "1234"}} {
+		if err := store.Put(ctx, put.ref, put.output); err != nil {
+			t.Fatalf("Put(%s) error = %v", put.ref, err)
+		}
+	}
+	// three outputs of 4 bytes exceed the budget of 10 bytes, so the oldest is evicted
+	if _, err := store.Get(ctx, "a"); !errors.Is(err, runtime.ErrOutputNotFound) {
+		t.Errorf("Get(a) error = %v, want %v", err, runtime.ErrOutputNotFound)
+	}
+	for _, ref := range []string{"b", "c"} {
+		if got, err := store.Get(ctx, ref); err != nil || got != "1234" {
+			t.Errorf("Get(%s) = %q, %v, want the output", ref, got, err)
+		}
+	}
+
+	if err := store.Put(ctx, "big", strings.Repeat("x", 11)); err == nil {
+		t.Errorf("Put() of an output exceeding the budget succeeded")
+	}
+	if got, err := store.Get(ctx, "c"); err != nil || got != "1234" {
+		t.Errorf("Get(c) after a rejected Put() = %q, %v, want the output", got, err)
+	}
+}
+
+func TestRuntimeStoresOutputsByDefault(t *testing.T) {
+	text := strings.Repeat("a", 100)
+	fake := runtimetest.NewFakeClient(
+		runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"`+text+`"}`)),
+		runtimetest.Reply("done"),
+	)
+	r := runtime.NewRuntime(fake, newToolkit(newEchoTool()), runtime.WithOutputLimit(runtime.OutputLimit{MaxTokens: 5}))
+
+	result, err := r.Run(context.Background(), userMessage("hi"))
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	ref := result.ToolExecutions[0].OutputRef
+	if got, err := r.ToolOutput(context.Background(), ref); err != nil || got != "echo:"+text {
+		t.Errorf("ToolOutput(%q) = %q, %v, want the full output", ref, got, err)
+	}
+
+	// a nil store discards the full output
+	fake = runtimetest.NewFakeClient(
+		runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"`+text+`"}`)),
+		runtimetest.Reply("done"),
+	)
+	r = runtime.NewRuntime(fake, newToolkit(newEchoTool()), runtime.WithOutputLimit(runtime.OutputLimit{MaxTokens: 5}), runtime.WithOutputStore(nil))
+	if result, err = r.Run(context.Background(), userMessage("hi")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if ref := result.ToolExecutions[0].OutputRef; ref != "" {
+		t.Errorf("output reference = %q, want none without an output store", ref)
+	}
+}
+
+func TestOutputLimitModes(t *testing.T) {
+	ascii := strings.Repeat("0123456789", 10)
+	// two bytes per character, so cutting at an arbitrary byte would split characters
+	multibyte := strings.Repeat("é", 50) + "z"
+	tests := []struct {
+		name string
+		text string
+		mode runtime.TruncationMode
+		// summary scripts the response to the summary request, if the mode makes one
+		summary []runtimetest.Response
+		want    string
+	}{
+		{
+			name: "keep head",
+			text: ascii,
+			mode: runtime.KeepHead,
+			want: "echo:012345678901234\n[last 85 of 105 characters omitted]",
+		},
+		{
+			name: "keep tail",
+			text: ascii,
+			mode: runtime.KeepTail,
+			want: "[first 85 of 105 characters omitted]\n01234567890123456789",
+		},
+		{
+			name: "keep head and tail",
+			text: ascii,
+			mode: runtime.KeepHeadAndTail,
+			want: "echo:01234\n[85 of 105 characters omitted]\n0123456789",
+		},
+		{
+			name:    "summarize",
+			text:    ascii,
+			mode:    runtime.SummarizeOutput,
+			summary: []runtimetest.Response{runtimetest.Reply("ten digits, ten times")},
+			want:    "Summary of the output, which has 105 characters:\nten digits, ten times",
+		},
+		{
+			name:    "summarize falls back to the head",
+			text:    ascii,
+			mode:    runtime.SummarizeOutput,
+			summary: []runtimetest.Response{runtimetest.Fail(errors.New("model unavailable"))},
+			want:    "echo:012345678901234\n[last 85 of 105 characters omitted]",
+		},
+		{
+			name: "keep head of multibyte output",
+			text: multibyte,
+			mode: runtime.KeepHead,
+			want: "echo:" + strings.Repeat("é", 7) + "\n[last 87 of 106 characters omitted]",
+		},
+		{
+			name: "keep tail of multibyte output",
+			text: multibyte,
+			mode: runtime.KeepTail,
+			want: "[first 87 of 106 characters omitted]\n" + strings.Repeat("é", 9) + "z",
+		},
+		{
+			name: "keep head and tail of multibyte output",
+			text: multibyte,
+			mode: runtime.KeepHeadAndTail,
+			want: "echo:éé\n[88 of 106 characters omitted]\n" + strings.Repeat("é", 4) + "z",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := []runtimetest.Response{runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"`+tt.text+`"}`))}
+			responses = append(responses, tt.summary...)
+			fake := runtimetest.NewFakeClient(append(responses, runtimetest.Reply("done"))...)
+			r := runtime.NewRuntime(fake, newToolkit(newEchoTool()),
+				runtime.WithOutputLimit(runtime.OutputLimit{MaxTokens: 5, Mode: tt.mode}),
+				runtime.WithOutputStore(nil),
+			)
+
+			result, err := r.Run(context.Background(), userMessage("hi"))
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			got := result.ToolExecutions[0].Result
+			if got != tt.want {
+				t.Errorf("result = %q, want %q", got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("result %q is not valid UTF-8", got)
+			}
+			if tool := result.Messages[2]; tool.Content != got {
+				t.Errorf("tool message = %q, want the shortened result", tool.Content)
+			}
+
+			if len(tt.summary) > 0 {
+				request := fake.Requests()[1]
+				if len(request.Tools) != 0 || !strings.Contains(request.Messages[1].Content, "echo:"+tt.text) {
+					t.Errorf("summary request = %+v, want the full output without tools", request)
+				}
+			}
+		})
+	}
+}
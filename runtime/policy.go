@@ -0,0 +1,37 @@
+package runtime
+
+// ToolErrorPolicy decides what happens when a tool call fails.
+type ToolErrorPolicy struct {
+	// Retries is the number of times a failed tool call is executed again before the policy gives up.
+	// Only execution failures, timeouts and busy tools are retried, unknown tools and invalid arguments never succeed on retry.
+	Retries int
+	// Abort stops the conversation with the *ToolError once retries are exhausted.
+	// Otherwise the error is reported to the model and the conversation continues.
+	Abort bool
+}
+
+// ContinueOnError reports failed tool calls to the model and continues the conversation. This is the default.
+func ContinueOnError() ToolErrorPolicy {
+	return ToolErrorPolicy{}
+}
+
+// AbortOnError stops the conversation as soon as a tool call fails.
+func AbortOnError() ToolErrorPolicy {
+	return ToolErrorPolicy{Abort: true}
+}
+
+// RetryOnError executes a failed tool call up to n more times before reporting the error to the model.
+func RetryOnError(n int) ToolErrorPolicy {
+	return ToolErrorPolicy{Retries: n}
+}
+
+func isRetryable(err *ToolError) bool {
+	return err.Kind == ToolErrorExecutionFailed || err.Kind == ToolErrorTimeout || err.Kind == ToolErrorBusy
+}
+
+func (r *Runtime) toolErrorPolicyFor(toolName string) ToolErrorPolicy {
+	if policy, ok := r.toolErrorPolicies[toolName]; ok {
+		return policy
+	}
+	return r.toolErrorPolicy
+}
@@ -0,0 +1,115 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrRateLimited = errors.New("client side rate limit exceeded")
+
+// RateLimit describes the capacity of a deployment. A limit of 0 is unlimited.
+type RateLimit struct {
+	RequestsPerMinute int
+	TokensPerMinute   int
+	// FailFast makes Wait return ErrRateLimited instead of blocking until capacity is available.
+	FailFast bool
+}
+
+// RateLimiter throttles chat completion requests by requests and estimated tokens per minute.
+// It is safe for concurrent use and can be shared by several Runtime instances using the same deployment.
+type RateLimiter struct {
+	mu       sync.Mutex
+	failFast bool
+	requests *bucket
+	tokens   *bucket
+}
+
+func NewRateLimiter(limit RateLimit) *RateLimiter {
+	now := time.Now()
+	return &RateLimiter{
+		failFast: limit.FailFast,
+		requests: newBucket(limit.RequestsPerMinute, now),
+		tokens:   newBucket(limit.TokensPerMinute, now),
+	}
+}
+
+// Wait blocks until a request consuming the given number of tokens can be sent, or ctx is done.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		wait := max(l.requests.wait(1, now), l.tokens.wait(tokens, now))
+		if wait == 0 {
+			l.requests.take(1)
+			l.tokens.take(tokens)
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		if l.failFast {
+			return ErrRateLimited
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Adjust corrects the token budget once the actual usage of a request is known.
+// A positive delta consumes additional tokens, a negative delta returns overestimated tokens.
+func (l *RateLimiter) Adjust(delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.take(delta)
+}
+
+// bucket is a token bucket refilled continuously with its capacity once per minute.
+// A nil bucket is unlimited.
+type bucket struct {
+	capacity  float64
+	available float64
+	updated   time.Time
+}
+
+func newBucket(perMinute int, now time.Time) *bucket {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &bucket{
+		capacity:  float64(perMinute),
+		available: float64(perMinute),
+		updated:   now,
+	}
+}
+
+// wait refills the bucket and returns how long to wait until n units are available.
+func (b *bucket) wait(n int, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.available = min(b.capacity, b.available+b.capacity*now.Sub(b.updated).Minutes())
+	b.updated = now
+
+	// requests larger than the capacity would never fit, let them through once the bucket is full
+	needed := min(float64(n), b.capacity)
+	if b.available >= needed {
+		return 0
+	}
+	return time.Duration((needed - b.available) / b.capacity * float64(time.Minute))
+}
+
+func (b *bucket) take(n int) {
+	if b == nil {
+		return
+	}
+	b.available = min(b.capacity, b.available-float64(n))
+}
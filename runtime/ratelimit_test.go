@@ -0,0 +1,105 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+func TestRateLimiterWaits(t *testing.T) {
+	// the bucket refills with 1000 tokens per second
+	limiter := runtime.NewRateLimiter(runtime.RateLimit{TokensPerMinute: 60000})
+	ctx := context.Background()
+	if err := limiter.Wait(ctx, 60000); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	start := time.Now()
+	if err := limiter.Wait(ctx, 100); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 80*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Wait() returned after %s, want about 100ms until the bucket is refilled", elapsed)
+	}
+}
+
+func TestRateLimiterWaitCancelled(t *testing.T) {
+	limiter := runtime.NewRateLimiter(runtime.RateLimit{RequestsPerMinute: 1})
+	if err := limiter.Wait(context.Background(), 0); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := limiter.Wait(ctx, 0); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Wait() returned after %s, want it to stop once ctx is done", elapsed)
+	}
+}
+
+func TestRateLimiterFailFast(t *testing.T) {
+	limiter := runtime.NewRateLimiter(runtime.RateLimit{RequestsPerMinute: 1, FailFast: true})
+	if err := limiter.Wait(context.Background(), 0); err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if err := limiter.Wait(context.Background(), 0); !errors.Is(err, runtime.ErrRateLimited) {
+		t.Errorf("Wait() error = %v, want %v", err, runtime.ErrRateLimited)
+	}
+}
+
+func TestRateLimiterSharedByRuntimes(t *testing.T) {
+	limiter := runtime.NewRateLimiter(runtime.RateLimit{RequestsPerMinute: 2, FailFast: true})
+	newRuntime := func() (*runtime.Runtime, *runtimetest.FakeClient) {
+		fake := runtimetest.NewFakeClient(runtimetest.Reply("done"), runtimetest.Reply("done"))
+		return runtime.NewRuntime(fake, newTestToolkit(), runtime.WithRateLimiter(limiter)), fake
+	}
+	first, firstFake := newRuntime()
+	second, secondFake := newRuntime()
+
+	for _, r := range []*runtime.Runtime{first, second} {
+		if _, err := r.Run(context.Background(), userMessage("hi")); err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+	}
+	// both runtimes used up the shared budget
+	if _, err := first.Run(context.Background(), userMessage("hi")); !errors.Is(err, runtime.ErrRateLimited) {
+		t.Errorf("Run() error = %v, want %v", err, runtime.ErrRateLimited)
+	}
+	if got := len(firstFake.Requests()) + len(secondFake.Requests()); got != 2 {
+		t.Errorf("requests = %d, want 2", got)
+	}
+}
+
+func TestRateLimiterRefundsFailedAttempts(t *testing.T) {
+	serverError := &openai.APIError{HTTPStatusCode: 500, Message: "overloaded"}
+	fake := runtimetest.NewFakeClient(
+		runtimetest.Fail(serverError),
+		runtimetest.Fail(serverError),
+		runtimetest.Fail(serverError),
+		runtimetest.Reply("done"),
+	)
+	// every attempt is estimated at roughly 300 tokens, so the budget only holds all of them if failures are refunded
+	limiter := runtime.NewRateLimiter(runtime.RateLimit{TokensPerMinute: 1000, FailFast: true})
+	r := runtime.NewRuntime(fake, newToolkit(),
+		runtime.WithRateLimiter(limiter),
+		runtime.WithRetryPolicy(runtime.RetryPolicy{MaxRetries: 3, InitialBackoff: time.Millisecond, RetryableStatusCodes: []int{500}}),
+	)
+
+	messages := []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("x", 1200)}}
+	if _, err := r.Run(context.Background(), messages); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := len(fake.Requests()); got != 4 {
+		t.Errorf("requests = %d, want 4", got)
+	}
+}
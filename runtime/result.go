@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ChatResult describes a conversation handled by the runtime.
+type ChatResult struct {
+	// Messages is the full transcript, starting with the messages the conversation was started with.
+	Messages []openai.ChatCompletionMessage
+	// NewMessages are the messages added by the runtime.
+	NewMessages []openai.ChatCompletionMessage
+	// FinalMessage is the last assistant message, if any.
+	FinalMessage *openai.ChatCompletionMessage
+	// FinishReason is the finish reason of the last round.
+	FinishReason   openai.FinishReason
+	Rounds         []Round
+	ToolExecutions []ToolExecution
+	Usage          Usage
+	// State is the state of the conversation, which can be saved and continued with ResumeState.
+	State *State
+}
+
+// Round describes a single chat completion of a conversation.
+type Round struct {
+	ResponseID   string              `json:"response_id"`
+	Model        string              `json:"model"`
+	FinishReason openai.FinishReason `json:"finish_reason"`
+	Usage        Usage               `json:"usage"`
+	Duration     time.Duration       `json:"duration"`
+}
+
+// ToolExecution describes a single tool call of a conversation.
+type ToolExecution struct {
+	// Round is the index of the round that requested the tool call, or -1 if the call was
+	// requested before the conversation was started, e.g. when resuming from messages.
+	Round      int    `json:"round"`
+	ToolCallID string `json:"tool_call_id"`
+	Tool       string `json:"tool"`
+	Arguments  string `json:"arguments"`
+	// Result is the content of the tool message the model receives, which is the formatted error if the call failed.
+	Result string `json:"result"`
+	// OutputRef is the ID the full output is kept under in the output store if Result was shortened.
+	OutputRef string        `json:"output_ref,omitempty"`
+	Err       *ToolError    `json:"error,omitempty"`
+	Attempts  int           `json:"attempts"`
+	Duration  time.Duration `json:"duration"`
+}
+
+// Run runs the conversation loop like ProcessChatContext and describes the conversation in a ChatResult.
+// The result is never nil and describes the conversation up to the point of failure if an error is returned.
+func (r *Runtime) Run(ctx context.Context, messages []openai.ChatCompletionMessage, opts ...RequestOption) (*ChatResult, error) {
+	state, err := r.run(ctx, newState(messages), opts, nil)
+	return state.result(), err
+}
+
+// RunStream streams the conversation like ProcessChatStream and describes it in a ChatResult.
+func (r *Runtime) RunStream(ctx context.Context, messages []openai.ChatCompletionMessage, handler StreamHandler, opts ...RequestOption) (*ChatResult, error) {
+	if handler == nil {
+		handler = func(StreamEvent) {}
+	}
+	state, err := r.run(ctx, newState(messages), opts, handler)
+	return state.result(), err
+}
+
+func (s *State) result() *ChatResult {
+	result := &ChatResult{
+		Messages:       s.Messages,
+		NewMessages:    s.Messages[min(s.Initial, len(s.Messages)):],
+		Rounds:         s.Rounds,
+		ToolExecutions: s.ToolExecutions,
+		Usage:          s.Usage,
+		State:          s,
+	}
+
+	if len(s.Rounds) > 0 {
+		result.FinishReason = s.Rounds[len(s.Rounds)-1].FinishReason
+	}
+	for i := len(result.NewMessages) - 1; i >= 0; i-- {
+		if result.NewMessages[i].Role == openai.ChatMessageRoleAssistant {
+			result.FinalMessage = &result.NewMessages[i]
+			break
+		}
+	}
+	return result
+}
@@ -0,0 +1,179 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"net"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// RetryPolicy controls how failed chat completion calls are retried.
+// The zero value disables retries.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// InitialBackoff is the wait before the first retry, it grows by Multiplier with every retry up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Multiplier     float64
+	// Jitter randomizes every wait by up to the given fraction, e.g. 0.2 for ±20%.
+	Jitter float64
+	// MaxElapsed caps the total time spent on a single chat completion including all retries.
+	// A value of 0 disables the cap.
+	MaxElapsed time.Duration
+	// RetryableStatusCodes are the HTTP status codes of API errors worth retrying.
+	RetryableStatusCodes []int
+}
+
+// DefaultRetryPolicy retries rate limits, timeouts and server errors up to 3 times.
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:           3,
+		InitialBackoff:       500 * time.Millisecond,
+		MaxBackoff:           30 * time.Second,
+		Multiplier:           2,
+		Jitter:               0.2,
+		MaxElapsed:           2 * time.Minute,
+		RetryableStatusCodes: []int{408, 409, 429, 500, 502, 503, 504},
+	}
+}
+
+// retryAfterPattern matches the hint Azure OpenAI puts in the message of rate limit errors.
+var retryAfterPattern = regexp.MustCompile(`(?i)retry after (\d+) seconds?`)
+
+// Retryable reports whether err is worth retrying according to the policy.
+// Network errors are always retried, API errors only if their status code is retryable.
+func (p RetryPolicy) Retryable(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return slices.Contains(p.RetryableStatusCodes, apiErr.HTTPStatusCode)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return slices.Contains(p.RetryableStatusCodes, reqErr.HTTPStatusCode)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// backoff returns how long to wait before the given retry attempt, starting at 0.
+// It returns false if the error should not be retried.
+func (p RetryPolicy) backoff(attempt int, err error, elapsed time.Duration) (time.Duration, bool) {
+	if attempt >= p.MaxRetries || !p.Retryable(err) {
+		return 0, false
+	}
+
+	wait, ok := retryAfter(err)
+	if !ok {
+		multiplier := p.Multiplier
+		if multiplier < 1 {
+			multiplier = 1
+		}
+		wait = time.Duration(float64(p.InitialBackoff) * math.Pow(multiplier, float64(attempt)))
+		if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+			wait = p.MaxBackoff
+		}
+		if p.Jitter > 0 {
+			wait = time.Duration(float64(wait) * (1 + p.Jitter*(2*rand.Float64()-1)))
+		}
+	}
+
+	if p.MaxElapsed > 0 && elapsed+wait > p.MaxElapsed {
+		return 0, false
+	}
+	return wait, true
+}
+
+// retryAfter extracts the wait requested by the server. Errors can provide it by implementing
+// RetryAfter() time.Duration, otherwise it is read from the message of an API error.
+func retryAfter(err error) (time.Duration, bool) {
+	var hinted interface{ RetryAfter() time.Duration }
+	if errors.As(err, &hinted) {
+		return hinted.RetryAfter(), true
+	}
+
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		if match := retryAfterPattern.FindStringSubmatch(apiErr.Message); match != nil {
+			seconds, convErr := strconv.Atoi(match[1])
+			if convErr == nil {
+				return time.Duration(seconds) * time.Second, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// createChatCompletion sends the request, streaming it to handler if set, and retries failures
+// according to the retry policy. A streamed round is not retried once events have been emitted.
+func (r *Runtime) createChatCompletion(ctx context.Context, req openai.ChatCompletionRequest, handler StreamHandler) (openai.ChatCompletionResponse, error) {
+	estimatedTokens := 0
+	if r.rateLimiter != nil {
+		estimatedTokens = EstimateRequestTokens(req)
+	}
+
+	start := time.Now()
+	for attempt := 0; ; attempt++ {
+		var response openai.ChatCompletionResponse
+		var err error
+		if r.rateLimiter != nil {
+			if err = r.rateLimiter.Wait(ctx, estimatedTokens); err != nil {
+				return response, err
+			}
+		}
+
+		emitted, reached := false, false
+		response, err = r.wrapCompletion(func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+			reached = true
+			if handler != nil {
+				return r.executeChatCompletionStream(ctx, req, func(event StreamEvent) {
+					emitted = true
+					handler(event)
+				})
+			}
+			return r.executeChatCompletion(ctx, req)
+		})(ctx, req)
+
+		// a streamed round answered by middleware has not been reported yet
+		if handler != nil && !reached && err == nil {
+			emitted = true
+			emitResponse(handler, response)
+		}
+
+		if r.rateLimiter != nil {
+			switch {
+			case err != nil:
+				// a failed attempt gives its estimate back, so retries do not drain the shared budget
+				r.rateLimiter.Adjust(-estimatedTokens)
+			case response.Usage.TotalTokens > 0:
+				r.rateLimiter.Adjust(response.Usage.TotalTokens - estimatedTokens)
+			}
+		}
+		if err == nil || emitted || ctx.Err() != nil {
+			return response, err
+		}
+
+		wait, ok := r.retryPolicy.backoff(attempt, err, time.Since(start))
+		if !ok {
+			return response, err
+		}
+
+		r.logger.Warn("retrying chat completion", "attempt", attempt+1, "wait", wait, "err", err)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return response, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
@@ -1,92 +1,473 @@
 package runtime
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/emilkje/go-openai-toolkit/toolkit"
 )
 
+// DefaultMaxIterations is the number of rounds a conversation may run unless WithMaxIterations is used.
+const DefaultMaxIterations = 20
+
 type Runtime struct {
-	client  *openai.Client
+	client  ChatClient
 	toolkit *toolkit.Toolkit
+	request openai.ChatCompletionRequest
+
+	maxIterations    int
+	maxToolCalls     int
+	maxRepeatedCalls int
+
+	parallelToolCalls  int
+	toolErrorFormatter ToolErrorFormatter
+	toolErrorPolicy    ToolErrorPolicy
+	toolErrorPolicies  map[string]ToolErrorPolicy
+	panicHandler       PanicHandler
+	toolTimeout        time.Duration
+	toolTimeouts       map[string]time.Duration
+
+	retryPolicy RetryPolicy
+	rateLimiter *RateLimiter
+
+	prices  PriceTable
+	usageMu sync.Mutex
+	usage   Usage
+
+	completionMiddleware []CompletionMiddleware
+	toolMiddleware       []ToolMiddleware
+	completionHooks      []CompletionHook
+	executeToolCall      ToolHandler
+
+	approver         Approver
+	approvalRequired map[string]bool
+	checkpointer     Checkpointer
+
+	contextStrategies []ContextStrategy
+	contextWindow     int
+	summaryPolicy     *SummaryPolicy
+
+	outputLimit  OutputLimit
+	outputLimits map[string]OutputLimit
+	outputStore  OutputStore
+
+	store         Store
+	systemMessage string
+	sessionsMu    sync.Mutex
+	sessions      map[string]*sessionLock
+
+	logger *slog.Logger
 }
 
-func NewRuntime(client *openai.Client, toolkit *toolkit.Toolkit) *Runtime {
-	return &Runtime{
+// PanicHandler is called with the panic of a tool once it has been recovered.
+type PanicHandler func(ctx context.Context, toolCall openai.ToolCall, err *PanicError)
+
+func NewRuntime(client ChatClient, toolkit *toolkit.Toolkit, opts ...Option) *Runtime {
+	r := &Runtime{
 		client:  client,
 		toolkit: toolkit,
+		request: openai.ChatCompletionRequest{
+			Model: openai.GPT4TurboPreview,
+		},
+		maxIterations:      DefaultMaxIterations,
+		toolErrorFormatter: DefaultToolErrorFormatter,
+		store:              NewMemoryStore(),
+		outputStore:        NewMemoryOutputStore(DefaultMaxStoredOutputs, DefaultMaxStoredOutputBytes),
+		sessions:           make(map[string]*sessionLock),
+		logger:             slog.Default(),
 	}
+	for _, opt := range opts {
+		opt.apply(r)
+	}
+	r.executeToolCall = r.wrapTool(r.executeTool)
+	return r
+}
+
+func (r *Runtime) ProcessChat(messages []openai.ChatCompletionMessage, opts ...RequestOption) ([]openai.ChatCompletionMessage, error) {
+	return r.ProcessChatContext(context.Background(), messages, opts...)
 }
 
-func (r *Runtime) ProcessChat(messages []openai.ChatCompletionMessage) ([]openai.ChatCompletionMessage, error) {
-	var err error
+// ProcessChatContext runs the conversation loop like ProcessChat, but stops as soon as ctx is done.
+// On cancellation the transcript collected so far is returned together with ctx.Err().
+func (r *Runtime) ProcessChatContext(ctx context.Context, messages []openai.ChatCompletionMessage, opts ...RequestOption) ([]openai.ChatCompletionMessage, error) {
+	result, err := r.Run(ctx, messages, opts...)
+	return result.Messages, err
+}
+
+// run drives the conversation loop and calls the completion hooks once it is done.
+// If handler is not nil every round is streamed to it.
+// The returned state is the state reached so far, even on error.
+func (r *Runtime) run(ctx context.Context, state *State, opts []RequestOption, handler StreamHandler) (*State, error) {
+	err := r.loop(ctx, state, opts, handler)
+	if len(r.completionHooks) > 0 {
+		result := state.result()
+		for _, hook := range r.completionHooks {
+			hook(ctx, result, err)
+		}
+	}
+	return state, err
+}
+
+func (r *Runtime) loop(ctx context.Context, state *State, opts []RequestOption, handler StreamHandler) error {
 	for {
-		var response openai.ChatCompletionResponse
-		response, err = r.executeChatCompletion(messages, r.toolkit.GetTools())
+		// execute the tool calls requested by the last round, or left over from a suspended conversation
+		if len(state.Pending) > 0 {
+			offset := len(state.Messages)
+			err := r.handleToolCalls(ctx, state, state.Pending, opts)
+			emitToolResults(handler, state.Messages[offset:])
+			// checkpoint suspended and aborted batches too, their results are part of the state
+			if checkpointErr := r.checkpoint(ctx, state); err == nil {
+				err = checkpointErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if r.maxIterations > 0 && len(state.Rounds) >= r.maxIterations {
+			return state.loopError(ErrMaxIterations)
+		}
+
+		r.summarize(ctx, state, opts)
+		req := r.newRequest(state.contextMessages(), opts)
+		r.fitContext(&req)
+		start := time.Now()
+		response, err := r.createChatCompletion(ctx, req, handler)
 		if err != nil {
-			return messages, err
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			return err
+		}
+		if len(response.Choices) == 0 {
+			return ErrNoChoices
 		}
 
+		usage := r.prices.usageOf(response, req.Model)
+		state.Usage.Add(usage)
+		r.addUsage(usage)
+		state.Rounds = append(state.Rounds, Round{
+			ResponseID:   response.ID,
+			Model:        response.Model,
+			FinishReason: response.Choices[0].FinishReason,
+			Usage:        usage,
+			Duration:     time.Since(start),
+		})
+
 		lastMessage := response.Choices[0].Message
-		messages = append(messages, lastMessage)
+		state.Messages = append(state.Messages, lastMessage)
 
 		if response.Choices[0].FinishReason != openai.FinishReasonToolCalls {
-			break // Exit the loop if the finish reason is not due to tool calls
+			return r.checkpoint(ctx, state) // Exit the loop if the finish reason is not due to tool calls
 		}
 
-		err = r.handleToolCalls(lastMessage.ToolCalls, &messages)
+		// the tool calls stay pending when a limit stops the loop, so resuming the state executes them
+		state.Pending = lastMessage.ToolCalls
+		err = r.checkToolCalls(state, lastMessage.ToolCalls)
+		if checkpointErr := r.checkpoint(ctx, state); err == nil {
+			err = checkpointErr
+		}
 		if err != nil {
-			return messages, err
+			return err
 		}
 	}
-	return messages, nil
 }
 
-func (r *Runtime) handleToolCalls(toolCalls []openai.ToolCall, messages *[]openai.ChatCompletionMessage) error {
+// checkToolCalls enforces the tool call limits before a batch of tool calls is executed.
+func (r *Runtime) checkToolCalls(state *State, toolCalls []openai.ToolCall) error {
+	if r.maxToolCalls > 0 && state.ToolCalls+len(toolCalls) > r.maxToolCalls {
+		return state.loopError(ErrMaxToolCalls)
+	}
+	state.ToolCalls += len(toolCalls)
+
 	for _, toolCall := range toolCalls {
-		toolResponse, err := r.executeTool(toolCall.Function.Name, toolCall.Function.Arguments)
+		key := toolCallKey(toolCall)
+		state.CallCounts[key]++
+		if r.maxRepeatedCalls > 0 && state.CallCounts[key] > r.maxRepeatedCalls {
+			return state.loopError(fmt.Errorf("%w: %s", ErrToolLoop, toolCall.Function.Name))
+		}
+	}
+	return nil
+}
+
+// toolCallKey identifies a tool call by its name and arguments, ignoring insignificant whitespace.
+func toolCallKey(toolCall openai.ToolCall) string {
+	args := []byte(toolCall.Function.Arguments)
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, args); err == nil {
+		args = compacted.Bytes()
+	}
+	return toolCall.Function.Name + "\x00" + string(args)
+}
+
+// handleToolCalls decides and executes the given tool calls of the last round and appends their results to the conversation.
+func (r *Runtime) handleToolCalls(ctx context.Context, state *State, toolCalls []openai.ToolCall, opts []RequestOption) error {
+	results := make([]*ToolExecution, len(toolCalls))
+	errs := make([]error, len(toolCalls))
+
+	var approved []int
+	var suspended []openai.ToolCall
+	for i, toolCall := range toolCalls {
+		decision, err := r.decide(ctx, state, toolCall)
 		if err != nil {
-			*messages = append(*messages, openai.ChatCompletionMessage{
-				Role:    openai.ChatMessageRoleTool,
-				Content: fmt.Sprintf("error executing tool %s: %v", toolCall.Function.Name, err),
+			return err
+		}
+
+		switch decision.Action {
+		case Approve:
+			approved = append(approved, i)
+		case Suspend:
+			suspended = append(suspended, toolCall)
+		default:
+			results[i] = r.denyTool(toolCall, decision.Reason)
+		}
+	}
+
+	// callCtx is cancelled when a tool call aborts the conversation, stopping the remaining calls
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if r.parallelToolCalls <= 1 || len(approved) == 1 {
+		for _, i := range approved {
+			if callCtx.Err() != nil {
+				break
+			}
+			results[i], errs[i] = r.callTool(callCtx, toolCalls[i])
+			if errs[i] != nil {
+				cancel()
+			}
+		}
+	} else {
+		sem := make(chan struct{}, r.parallelToolCalls)
+		var wg sync.WaitGroup
+		for _, i := range approved {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				select {
+				case sem <- struct{}{}:
+				case <-callCtx.Done():
+					return
+				}
+				defer func() { <-sem }()
+				results[i], errs[i] = r.callTool(callCtx, toolCalls[i])
+				if errs[i] != nil {
+					cancel()
+				}
+			}()
+		}
+		wg.Wait()
+	}
+
+	// keep the transcript deterministic by appending the results in the original order
+	for _, result := range results {
+		if result != nil {
+			result.Round = len(state.Rounds) - 1
+			r.limitOutput(ctx, state, result, opts)
+			state.ToolExecutions = append(state.ToolExecutions, *result)
+			state.Messages = append(state.Messages, openai.ChatCompletionMessage{
+				Role:       openai.ChatMessageRoleTool,
+				Content:    result.Result,
+				ToolCallID: result.ToolCallID,
 			})
-			// Consider whether to continue or return the error based on your use case
-			continue // In this case, we continue to attempt other tool calls
 		}
+	}
+	// calls that were suspended or stopped by an abort remain pending
+	state.Pending = pendingToolCalls(state.Messages)
 
-		*messages = append(*messages, openai.ChatCompletionMessage{
-			Role:       openai.ChatMessageRoleTool,
-			Content:    toolResponse,
-			ToolCallID: toolCall.ID,
-		})
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	if len(suspended) > 0 {
+		return &SuspendedError{Messages: state.Messages, Pending: suspended, State: state}
 	}
 	return nil
 }
 
-func (r *Runtime) executeTool(toolName string, args string) (string, error) {
-	tool, exists := r.toolkit.GetTool(toolName)
-	if !exists {
-		return "", fmt.Errorf("tool %s not found", toolName)
+func (r *Runtime) denyTool(toolCall openai.ToolCall, reason string) *ToolExecution {
+	toolErr := &ToolError{
+		Kind:       ToolErrorDenied,
+		Tool:       toolCall.Function.Name,
+		ToolCallID: toolCall.ID,
+		Err:        errors.New(reason),
+	}
+	return &ToolExecution{
+		ToolCallID: toolCall.ID,
+		Tool:       toolCall.Function.Name,
+		Arguments:  toolCall.Function.Arguments,
+		Result:     r.toolErrorFormatter(toolErr),
+		Err:        toolErr,
+	}
+}
+
+// callTool executes a single tool call according to the tool error policy.
+// The execution is nil if the call was interrupted by ctx being done. The error is only set when the policy aborts the conversation.
+func (r *Runtime) callTool(ctx context.Context, toolCall openai.ToolCall) (*ToolExecution, error) {
+	policy := r.toolErrorPolicyFor(toolCall.Function.Name)
+	execution := &ToolExecution{
+		ToolCallID: toolCall.ID,
+		Tool:       toolCall.Function.Name,
+		Arguments:  toolCall.Function.Arguments,
+		Attempts:   1,
+	}
+	start := time.Now()
+
+	toolResponse, err := r.executeToolCall(ctx, toolCall)
+	for err != nil && ctx.Err() == nil && execution.Attempts <= policy.Retries && isRetryable(newToolError(toolCall, err)) {
+		execution.Attempts++
+		toolResponse, err = r.executeToolCall(ctx, toolCall)
+	}
+	execution.Duration = time.Since(start)
+
+	if err != nil && ctx.Err() != nil {
+		return nil, nil
+	}
+
+	execution.Result = toolResponse
+	if err == nil {
+		return execution, nil
+	}
+
+	execution.Err = newToolError(toolCall, err)
+	execution.Result = r.toolErrorFormatter(execution.Err)
+	if policy.Abort {
+		return execution, execution.Err
+	}
+	return execution, nil
+}
+
+// executeTool executes a tool call within its timeout. Tools that do not observe the cancellation
+// of their context are abandoned when the timeout fires and finish in the background.
+// If the timeout fires while the tool is still in use by another invocation, the call fails with toolkit.ErrToolBusy.
+func (r *Runtime) executeTool(ctx context.Context, toolCall openai.ToolCall) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
 	}
 
-	parser, ok := tool.(toolkit.Parsable)
-	if ok {
-		if err := parser.ParseArgument(args); err != nil {
+	timeout := r.toolTimeoutFor(toolCall.Function.Name)
+	if timeout <= 0 && ctx.Done() == nil {
+		return r.invokeTool(ctx, toolCall, nil)
+	}
+
+	// the tool runs in its own goroutine so the conversation stops promptly on cancellation,
+	// even if the tool ignores ctx; a tool that does not return in time is abandoned
+	var toolCtx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		toolCtx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		toolCtx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	var started atomic.Bool
+	go func() {
+		result, err := r.invokeTool(toolCtx, toolCall, func() { started.Store(true) })
+		done <- outcome{result: result, err: err}
+	}()
+
+	select {
+	case o := <-done:
+		if errors.Is(o.err, toolkit.ErrToolBusy) {
+			return "", o.err
+		}
+		if o.err != nil && ctx.Err() == nil && errors.Is(toolCtx.Err(), context.DeadlineExceeded) {
+			return "", timeoutError(toolCall, timeout)
+		}
+		return o.result, o.err
+	case <-toolCtx.Done():
+		if err := ctx.Err(); err != nil {
 			return "", err
 		}
+		if !started.Load() {
+			return "", busyError(toolCall, timeout)
+		}
+		return "", timeoutError(toolCall, timeout)
 	}
-	return tool.Execute(), nil
 }
 
-func (r *Runtime) executeChatCompletion(messages []openai.ChatCompletionMessage, tools []openai.Tool) (openai.ChatCompletionResponse, error) {
-	return r.client.CreateChatCompletion(
-		context.Background(),
-		openai.ChatCompletionRequest{
-			Model:    openai.GPT4TurboPreview,
-			Messages: messages,
-			Tools:    tools,
-		},
-	)
+func timeoutError(toolCall openai.ToolCall, timeout time.Duration) error {
+	return fmt.Errorf("tool %s timed out after %s: %w", toolCall.Function.Name, timeout, context.DeadlineExceeded)
+}
+
+func busyError(toolCall openai.ToolCall, timeout time.Duration) error {
+	return fmt.Errorf("tool %s was busy for %s: %w", toolCall.Function.Name, timeout, toolkit.ErrToolBusy)
+}
+
+func (r *Runtime) toolTimeoutFor(toolName string) time.Duration {
+	if timeout, ok := r.toolTimeouts[toolName]; ok {
+		return timeout
+	}
+	if tool, ok := r.toolkit.Lookup(toolName); ok {
+		if timed, ok := tool.(toolkit.Timed); ok {
+			return timed.Timeout()
+		}
+	}
+	return r.toolTimeout
+}
+
+// invokeTool invokes the tool and recovers any panic raised while parsing its arguments or executing it.
+// started, if not nil, is called once the tool is prepared and about to be executed.
+func (r *Runtime) invokeTool(ctx context.Context, toolCall openai.ToolCall, started func()) (result string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			panicErr := &PanicError{Value: recovered, Stack: debug.Stack()}
+			r.logger.Error("recovered panic in tool",
+				"tool", toolCall.Function.Name,
+				"tool_call_id", toolCall.ID,
+				"panic", recovered,
+				"stack", string(panicErr.Stack))
+			if r.panicHandler != nil {
+				r.panicHandler(ctx, toolCall, panicErr)
+			}
+			result, err = "", panicErr
+		}
+	}()
+
+	tool, release, err := r.toolkit.Prepare(ctx, toolCall.Function.Name, toolCall.Function.Arguments)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	if started != nil {
+		started()
+	}
+	return toolkit.Execute(ctx, tool)
+}
+
+func (r *Runtime) newRequest(messages []openai.ChatCompletionMessage, opts []RequestOption) openai.ChatCompletionRequest {
+	req := r.request
+	for _, opt := range opts {
+		opt(&req)
+	}
+	req.Messages = messages
+	req.Tools = r.toolkit.GetTools()
+	return req
+}
+
+func (r *Runtime) executeChatCompletion(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	return r.client.CreateChatCompletion(ctx, req)
 }
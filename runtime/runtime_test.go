@@ -0,0 +1,937 @@
+package runtime_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+	"github.com/emilkje/go-openai-toolkit/toolkit"
+)
+
+type echoArgs struct {
+	Text string `json:"text"`
+}
+
+// echoTool returns its text and counts its executions.
+type echoTool struct {
+	toolkit.Tool[echoArgs]
+	calls *atomic.Int32
+}
+
+func newEchoTool() *echoTool {
+	return &echoTool{Tool: &toolkit.ToolArgs[echoArgs]{}, calls: new(atomic.Int32)}
+}
+
+func (t *echoTool) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{Name: "echo", Parameters: jsonschema.Definition{Type: jsonschema.Object}}
+}
+
+func (t *echoTool) Execute() string {
+	t.calls.Add(1)
+	return "echo:" + t.GetArguments().Text
+}
+
+func (t *echoTool) NewInstance() toolkit.Definable {
+	return &echoTool{Tool: &toolkit.ToolArgs[echoArgs]{}, calls: t.calls}
+}
+
+var _ toolkit.Instantiable = (*echoTool)(nil)
+
+// blockingTool implements only Execute and blocks until it is released, ignoring cancellation.
+type blockingTool struct {
+	toolkit.Tool[echoArgs]
+	release chan struct{}
+}
+
+func newBlockingTool(t *testing.T) *blockingTool {
+	tool := &blockingTool{Tool: &toolkit.ToolArgs[echoArgs]{}, release: make(chan struct{})}
+	t.Cleanup(func() { close(tool.release) })
+	return tool
+}
+
+func (t *blockingTool) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{Name: "block", Parameters: jsonschema.Definition{Type: jsonschema.Object}}
+}
+
+func (t *blockingTool) Execute() string {
+	<-t.release
+	return "released"
+}
+
+// funcTool runs fn with the text argument, so a test can make a tool sleep, fail or panic.
+type funcTool struct {
+	toolkit.Tool[echoArgs]
+	name string
+	fn   func(ctx context.Context, text string) (string, error)
+}
+
+func newFuncTool(name string, fn func(ctx context.Context, text string) (string, error)) *funcTool {
+	return &funcTool{Tool: &toolkit.ToolArgs[echoArgs]{}, name: name, fn: fn}
+}
+
+func (t *funcTool) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{Name: t.name, Parameters: jsonschema.Definition{Type: jsonschema.Object}}
+}
+
+func (t *funcTool) ExecuteContext(ctx context.Context) (string, error) {
+	return t.fn(ctx, t.GetArguments().Text)
+}
+
+func (t *funcTool) NewInstance() toolkit.Definable {
+	return newFuncTool(t.name, t.fn)
+}
+
+// newTestToolkit registers echo together with tools that sleep, fail, fail twice and panic.
+func newTestToolkit() *toolkit.Toolkit {
+	var flakyCalls atomic.Int32
+	tk := newToolkit(newEchoTool())
+	tk.RegisterContextTool(
+		newFuncTool("sleep", func(ctx context.Context, text string) (string, error) {
+			delay, err := time.ParseDuration(text)
+			if err != nil {
+				return "", err
+			}
+			select {
+			case <-time.After(delay):
+				return "slept " + text, nil
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+		}),
+		newFuncTool("fail", func(context.Context, string) (string, error) {
+			return "", errors.New("boom")
+		}),
+		newFuncTool("flaky", func(context.Context, string) (string, error) {
+			if flakyCalls.Add(1) <= 2 {
+				return "", errors.New("try again")
+			}
+			return "ok", nil
+		}),
+		newFuncTool("panic", func(context.Context, string) (string, error) {
+			panic("tool exploded")
+		}),
+	)
+	return tk
+}
+
+func userMessage(content string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: content}}
+}
+
+func toolResults(messages []openai.ChatCompletionMessage) []string {
+	var results []string
+	for _, message := range messages {
+		if message.Role == openai.ChatMessageRoleTool {
+			results = append(results, message.ToolCallID+"="+message.Content)
+		}
+	}
+	return results
+}
+
+func newToolkit(tools ...toolkit.Callable) *toolkit.Toolkit {
+	tk := toolkit.NewToolkit()
+	for _, tool := range tools {
+		tk.RegisterTool(tool)
+	}
+	return tk
+}
+
+func roles(messages []openai.ChatCompletionMessage) []string {
+	var roles []string
+	for _, message := range messages {
+		roles = append(roles, message.Role)
+	}
+	return roles
+}
+
+func TestRunPendingToolCalls(t *testing.T) {
+	user := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hi"}
+	assistant := func(ids ...string) openai.ChatCompletionMessage {
+		message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant}
+		for _, id := range ids {
+			message.ToolCalls = append(message.ToolCalls, runtimetest.ToolCall(id, "echo", `{"text":"a"}`))
+		}
+		return message
+	}
+	result := func(id string) openai.ChatCompletionMessage {
+		return openai.ChatCompletionMessage{Role: openai.ChatMessageRoleTool, Content: "echo:a", ToolCallID: id}
+	}
+
+	tests := []struct {
+		name      string
+		messages  []openai.ChatCompletionMessage
+		wantCalls int32
+		wantRoles []string
+	}{
+		{
+			name:      "unanswered calls",
+			messages:  []openai.ChatCompletionMessage{user, assistant("c1")},
+			wantCalls: 1,
+			wantRoles: []string{"user", "assistant", "tool"},
+		},
+		{
+			name:      "partially answered calls",
+			messages:  []openai.ChatCompletionMessage{user, assistant("c1", "c2"), result("c1")},
+			wantCalls: 1,
+			wantRoles: []string{"user", "assistant", "tool", "tool"},
+		},
+		{
+			name:      "calls followed by a user message",
+			messages:  []openai.ChatCompletionMessage{user, assistant("c1"), user},
+			wantCalls: 0,
+			wantRoles: []string{"user", "assistant", "user"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			echo := newEchoTool()
+			fake := runtimetest.NewFakeClient(runtimetest.Reply("done"))
+			r := runtime.NewRuntime(fake, newToolkit(echo))
+
+			if _, err := r.Run(context.Background(), tt.messages); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if got := echo.calls.Load(); got != tt.wantCalls {
+				t.Errorf("tool executions = %d, want %d", got, tt.wantCalls)
+			}
+			if got := roles(fake.Requests()[0].Messages); !slices.Equal(got, tt.wantRoles) {
+				t.Errorf("request roles = %v, want %v", got, tt.wantRoles)
+			}
+		})
+	}
+}
+
+func TestSessionSuspended(t *testing.T) {
+	echo := newEchoTool()
+	fake := runtimetest.NewFakeClient(
+		runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"a"}`)),
+		runtimetest.Reply("sent"),
+		runtimetest.Reply("hello again"),
+	)
+	r := runtime.NewRuntime(fake, newToolkit(echo),
+		runtime.WithApprovalRequired("echo"),
+		runtime.WithApprover(func(context.Context, openai.ToolCall) (runtime.Decision, error) {
+			return runtime.Suspended(), nil
+		}),
+	)
+	session := r.Session("s1")
+	ctx := context.Background()
+
+	if _, err := session.Resume(ctx, nil); !errors.Is(err, runtime.ErrNothingToResume) {
+		t.Fatalf("Resume() on a new session error = %v, want %v", err, runtime.ErrNothingToResume)
+	}
+	if _, err := session.Send("send it"); !errors.Is(err, runtime.ErrSuspended) {
+		t.Fatalf("Send() error = %v, want %v", err, runtime.ErrSuspended)
+	}
+	_, err := session.Send("never mind")
+	var suspended *runtime.SuspendedError
+	if !errors.As(err, &suspended) || len(suspended.Pending) != 1 {
+		t.Fatalf("Send() on a suspended session error = %v, want a *SuspendedError with 1 pending call", err)
+	}
+	if suspended.State.ID != session.ID() {
+		t.Errorf("suspended state ID = %q, want the session ID %q", suspended.State.ID, session.ID())
+	}
+	if messages, _ := session.Messages(ctx); !slices.Equal(roles(messages), []string{"user", "assistant"}) {
+		t.Fatalf("transcript roles = %v, want [user assistant]", roles(messages))
+	}
+
+	if _, err = session.Resume(ctx, map[string]runtime.Decision{"c1": runtime.Approved()}); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+	if _, err = session.Resume(ctx, nil); !errors.Is(err, runtime.ErrNothingToResume) {
+		t.Errorf("Resume() of a resumed session error = %v, want %v", err, runtime.ErrNothingToResume)
+	}
+	result, err := session.Send("hi")
+	if err != nil {
+		t.Fatalf("Send() after Resume() error = %v", err)
+	}
+	if result.FinalMessage.Content != "hello again" {
+		t.Errorf("final message = %q, want %q", result.FinalMessage.Content, "hello again")
+	}
+	if got := echo.calls.Load(); got != 1 {
+		t.Errorf("tool executions = %d, want 1", got)
+	}
+	want := []string{"user", "assistant", "tool", "assistant", "user", "assistant"}
+	if messages, _ := session.Messages(ctx); !slices.Equal(roles(messages), want) {
+		t.Errorf("transcript roles = %v, want %v", roles(messages), want)
+	}
+}
+
+func TestRunCancelledDuringExecute(t *testing.T) {
+	fake := runtimetest.NewFakeClient(runtimetest.CallTools(runtimetest.ToolCall("c1", "block", `{}`)))
+	r := runtime.NewRuntime(fake, newToolkit(newBlockingTool(t)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := r.Run(ctx, []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}})
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Run() returned after %s, want it to stop once ctx is done", elapsed)
+	}
+}
+
+func TestRunAbandonedToolReportsBusy(t *testing.T) {
+	// the blocking tool keeps its arguments and cannot be instantiated, so an abandoned call keeps it in use
+	tk := newToolkit(newBlockingTool(t))
+	run := func() runtime.ToolExecution {
+		fake := runtimetest.NewFakeClient(runtimetest.CallTools(runtimetest.ToolCall("c1", "block", `{}`)), runtimetest.Reply("done"))
+		r := runtime.NewRuntime(fake, tk, runtime.WithToolTimeout(20*time.Millisecond))
+		result, err := r.Run(context.Background(), userMessage("hi"))
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		return result.ToolExecutions[0]
+	}
+
+	if execution := run(); execution.Err == nil || execution.Err.Kind != runtime.ToolErrorTimeout {
+		t.Fatalf("first execution error = %v, want kind %s", execution.Err, runtime.ToolErrorTimeout)
+	}
+	execution := run()
+	if execution.Err == nil || execution.Err.Kind != runtime.ToolErrorBusy {
+		t.Fatalf("second execution error = %v, want kind %s", execution.Err, runtime.ToolErrorBusy)
+	}
+	if !errors.Is(execution.Err, toolkit.ErrToolBusy) {
+		t.Errorf("second execution error = %v, want it to wrap %v", execution.Err, toolkit.ErrToolBusy)
+	}
+}
+
+func TestOutputStoreReferences(t *testing.T) {
+	store := runtime.NewMemoryOutputStore(2, 0)
+	outputs := map[string]string{}
+	for _, text := range []string{strings.Repeat("a", 100), strings.Repeat("b", 100), strings.Repeat("c", 100)} {
+		fake := runtimetest.NewFakeClient(
+			runtimetest.CallTools(runtimetest.ToolCall("call_0", "echo", `{"text":"`+text+`"}`)),
+			runtimetest.Reply("done"),
+		)
+		r := runtime.NewRuntime(fake, newToolkit(newEchoTool()),
+			runtime.WithOutputLimit(runtime.OutputLimit{MaxTokens: 5}),
+			runtime.WithOutputStore(store),
+		)
+		result, err := r.Run(context.Background(), []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "hi"}})
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+
+		execution := result.ToolExecutions[0]
+		if execution.OutputRef == "" || !strings.Contains(execution.Result, execution.OutputRef) {
+			t.Fatalf("result %q does not refer to the stored output %q", execution.Result, execution.OutputRef)
+		}
+		outputs[execution.OutputRef] = "echo:" + text
+	}
+
+	if len(outputs) != 3 {
+		t.Fatalf("references = %v, want one per conversation", outputs)
+	}
+	found := 0
+	for ref, want := range outputs {
+		got, err := store.Get(context.Background(), ref)
+		if errors.Is(err, runtime.ErrOutputNotFound) {
+			continue
+		}
+		if got != want {
+			t.Errorf("output %s = %q, want %q", ref, got, want)
+		}
+		found++
+	}
+	if found != 2 {
+		t.Errorf("stored outputs = %d, want the 2 most recent", found)
+	}
+}
+
+func TestRunToolCallOrder(t *testing.T) {
+	tests := []struct {
+		name     string
+		parallel int
+	}{
+		{name: "sequential", parallel: 1},
+		{name: "parallel", parallel: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the first call finishes last, results must still follow the order of the calls
+			fake := runtimetest.NewFakeClient(
+				runtimetest.CallTools(
+					runtimetest.ToolCall("c1", "sleep", `{"text":"40ms"}`),
+					runtimetest.ToolCall("c2", "sleep", `{"text":"20ms"}`),
+					runtimetest.ToolCall("c3", "echo", `{"text":"now"}`),
+					runtimetest.ToolCall("c4", "sleep", `{"text":"1ms"}`),
+				),
+				runtimetest.Reply("done"),
+			)
+			r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithParallelToolCalls(tt.parallel))
+
+			result, err := r.Run(context.Background(), userMessage("hi"))
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			want := []string{"c1=slept 40ms", "c2=slept 20ms", "c3=echo:now", "c4=slept 1ms"}
+			if got := toolResults(result.Messages); !slices.Equal(got, want) {
+				t.Errorf("tool results = %v, want %v", got, want)
+			}
+			for i, execution := range result.ToolExecutions {
+				if execution.ToolCallID != fmt.Sprintf("c%d", i+1) || execution.Round != 0 {
+					t.Errorf("execution %d = %s in round %d, want c%d in round 0", i, execution.ToolCallID, execution.Round, i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestRunStreamAssemblesToolCalls(t *testing.T) {
+	toolCalls := []openai.ToolCall{
+		runtimetest.ToolCall("c1", "echo", `{"text":"first"}`),
+		runtimetest.ToolCall("c2", "echo", `{"text":"second one"}`),
+	}
+	fake := runtimetest.NewFakeClient(
+		runtimetest.CallTools(toolCalls...),
+		runtimetest.Reply("all done here"),
+	)
+	r := runtime.NewRuntime(fake, newTestToolkit())
+
+	var content strings.Builder
+	var messages []openai.ChatCompletionMessage
+	var results []string
+	result, err := r.RunStream(context.Background(), userMessage("hi"), func(event runtime.StreamEvent) {
+		switch event.Type {
+		case runtime.StreamEventContent:
+			content.WriteString(event.Content)
+		case runtime.StreamEventMessage:
+			messages = append(messages, *event.Message)
+		case runtime.StreamEventToolResult:
+			results = append(results, event.Message.Content)
+		}
+	})
+	if err != nil {
+		t.Fatalf("RunStream() error = %v", err)
+	}
+
+	if len(messages) != 2 {
+		t.Fatalf("assistant message events = %d, want 2", len(messages))
+	}
+	got := messages[0].ToolCalls
+	if len(got) != len(toolCalls) {
+		t.Fatalf("assembled tool calls = %+v, want %+v", got, toolCalls)
+	}
+	for i := range toolCalls {
+		if got[i].ID != toolCalls[i].ID || got[i].Function.Name != toolCalls[i].Function.Name || got[i].Function.Arguments != toolCalls[i].Function.Arguments {
+			t.Errorf("tool call %d = %+v, want %+v", i, got[i], toolCalls[i])
+		}
+	}
+	if want := []string{"echo:first", "echo:second one"}; !slices.Equal(results, want) {
+		t.Errorf("tool result events = %v, want %v", results, want)
+	}
+	if content.String() != "all done here" || messages[1].Content != "all done here" {
+		t.Errorf("streamed content = %q, final message = %q, want %q", content.String(), messages[1].Content, "all done here")
+	}
+	if want := []string{"user", "assistant", "tool", "tool", "assistant"}; !slices.Equal(roles(result.Messages), want) {
+		t.Errorf("transcript roles = %v, want %v", roles(result.Messages), want)
+	}
+}
+
+func TestRunToolErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		toolCall     openai.ToolCall
+		opts         []runtime.Option
+		wantKind     runtime.ToolErrorKind
+		wantAbort    bool
+		wantAttempts int
+	}{
+		{
+			name:         "unknown tool",
+			toolCall:     runtimetest.ToolCall("c1", "missing", `{}`),
+			wantKind:     runtime.ToolErrorNotFound,
+			wantAttempts: 1,
+		},
+		{
+			name:         "invalid arguments are not retried",
+			toolCall:     runtimetest.ToolCall("c1", "echo", `{"text":`),
+			opts:         []runtime.Option{runtime.WithToolErrorPolicy(runtime.RetryOnError(2))},
+			wantKind:     runtime.ToolErrorInvalidArguments,
+			wantAttempts: 1,
+		},
+		{
+			name:         "execution failure continues by default",
+			toolCall:     runtimetest.ToolCall("c1", "fail", `{}`),
+			wantKind:     runtime.ToolErrorExecutionFailed,
+			wantAttempts: 1,
+		},
+		{
+			name:         "execution failure aborts",
+			toolCall:     runtimetest.ToolCall("c1", "fail", `{}`),
+			opts:         []runtime.Option{runtime.WithToolErrorPolicy(runtime.AbortOnError())},
+			wantKind:     runtime.ToolErrorExecutionFailed,
+			wantAbort:    true,
+			wantAttempts: 1,
+		},
+		{
+			name:     "policy of another tool does not apply",
+			toolCall: runtimetest.ToolCall("c1", "fail", `{}`),
+			opts: []runtime.Option{
+				runtime.WithToolErrorPolicy(runtime.AbortOnError()),
+				runtime.WithToolErrorPolicyFor("fail", runtime.ContinueOnError()),
+			},
+			wantKind:     runtime.ToolErrorExecutionFailed,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retries exhausted",
+			toolCall:     runtimetest.ToolCall("c1", "fail", `{}`),
+			opts:         []runtime.Option{runtime.WithToolErrorPolicyFor("fail", runtime.RetryOnError(2))},
+			wantKind:     runtime.ToolErrorExecutionFailed,
+			wantAttempts: 3,
+		},
+		{
+			name:         "retry succeeds",
+			toolCall:     runtimetest.ToolCall("c1", "flaky", `{}`),
+			opts:         []runtime.Option{runtime.WithToolErrorPolicy(runtime.RetryOnError(2))},
+			wantAttempts: 3,
+		},
+		{
+			name:         "panic",
+			toolCall:     runtimetest.ToolCall("c1", "panic", `{}`),
+			wantKind:     runtime.ToolErrorPanic,
+			wantAttempts: 1,
+		},
+		{
+			name:         "timeout",
+			toolCall:     runtimetest.ToolCall("c1", "sleep", `{"text":"1s"}`),
+			opts:         []runtime.Option{runtime.WithToolTimeoutFor("sleep", 20*time.Millisecond)},
+			wantKind:     runtime.ToolErrorTimeout,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := runtimetest.NewFakeClient(runtimetest.CallTools(tt.toolCall), runtimetest.Reply("done"))
+			r := runtime.NewRuntime(fake, newTestToolkit(), tt.opts...)
+
+			result, err := r.Run(context.Background(), userMessage("hi"))
+
+			var toolErr *runtime.ToolError
+			if tt.wantAbort {
+				if !errors.As(err, &toolErr) || toolErr.Kind != tt.wantKind {
+					t.Fatalf("Run() error = %v, want a *ToolError of kind %s", err, tt.wantKind)
+				}
+			} else if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			execution := result.ToolExecutions[0]
+			if tt.wantKind == "" {
+				if execution.Err != nil {
+					t.Errorf("execution error = %v, want none", execution.Err)
+				}
+			} else if execution.Err == nil || execution.Err.Kind != tt.wantKind {
+				t.Errorf("execution error = %v, want kind %s", execution.Err, tt.wantKind)
+			}
+			if execution.Attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", execution.Attempts, tt.wantAttempts)
+			}
+			if got := toolResults(result.Messages); len(got) != 1 || !strings.HasPrefix(got[0], "c1=") {
+				t.Errorf("tool results = %v, want a single result for c1", got)
+			}
+		})
+	}
+}
+
+func TestRunPanicHandler(t *testing.T) {
+	fake := runtimetest.NewFakeClient(runtimetest.CallTools(runtimetest.ToolCall("c1", "panic", `{}`)), runtimetest.Reply("done"))
+	var recovered *runtime.PanicError
+	r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithPanicHandler(func(_ context.Context, _ openai.ToolCall, err *runtime.PanicError) {
+		recovered = err
+	}))
+
+	if _, err := r.Run(context.Background(), userMessage("hi")); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if recovered == nil || recovered.Value != "tool exploded" || len(recovered.Stack) == 0 {
+		t.Errorf("recovered panic = %+v, want the value and stack of the panic", recovered)
+	}
+}
+
+// retryAfterError is a rate limit error telling the client how long to wait.
+type retryAfterError struct {
+	*openai.APIError
+	wait time.Duration
+}
+
+func (e *retryAfterError) RetryAfter() time.Duration { return e.wait }
+func (e *retryAfterError) Unwrap() error             { return e.APIError }
+
+func TestRetryPolicy(t *testing.T) {
+	serverError := &openai.APIError{HTTPStatusCode: 500, Message: "overloaded"}
+	badRequest := &openai.APIError{HTTPStatusCode: 400, Message: "invalid request"}
+	policy := runtime.RetryPolicy{
+		MaxRetries:           2,
+		InitialBackoff:       time.Millisecond,
+		Multiplier:           2,
+		RetryableStatusCodes: []int{429, 500},
+	}
+	// a backoff the test would notice, so passing fast proves the server's hint was used
+	slow := policy
+	slow.InitialBackoff = time.Minute
+
+	tests := []struct {
+		name         string
+		policy       runtime.RetryPolicy
+		responses    []runtimetest.Response
+		wantRequests int
+		wantErr      error
+	}{
+		{
+			name:         "server errors are retried",
+			policy:       policy,
+			responses:    []runtimetest.Response{runtimetest.Fail(serverError), runtimetest.Fail(serverError), runtimetest.Reply("done")},
+			wantRequests: 3,
+		},
+		{
+			name:         "retries exhausted",
+			policy:       policy,
+			responses:    []runtimetest.Response{runtimetest.Fail(serverError), runtimetest.Fail(serverError), runtimetest.Fail(serverError)},
+			wantRequests: 3,
+			wantErr:      serverError,
+		},
+		{
+			name:         "client errors are not retried",
+			policy:       policy,
+			responses:    []runtimetest.Response{runtimetest.Fail(badRequest)},
+			wantRequests: 1,
+			wantErr:      badRequest,
+		},
+		{
+			name:   "Retry-After hint",
+			policy: slow,
+			responses: []runtimetest.Response{
+				runtimetest.Fail(&retryAfterError{APIError: &openai.APIError{HTTPStatusCode: 429}, wait: time.Millisecond}),
+				runtimetest.Reply("done"),
+			},
+			wantRequests: 2,
+		},
+		{
+			name:   "retry after hint in the message",
+			policy: slow,
+			responses: []runtimetest.Response{
+				runtimetest.Fail(&openai.APIError{HTTPStatusCode: 429, Message: "Rate limit exceeded. Please retry after 0 seconds."}),
+				runtimetest.Reply("done"),
+			},
+			wantRequests: 2,
+		},
+		{
+			name: "waiting longer than MaxElapsed",
+			policy: runtime.RetryPolicy{
+				MaxRetries:           2,
+				InitialBackoff:       time.Minute,
+				MaxElapsed:           time.Second,
+				RetryableStatusCodes: []int{500},
+			},
+			responses:    []runtimetest.Response{runtimetest.Fail(serverError), runtimetest.Reply("done")},
+			wantRequests: 1,
+			wantErr:      serverError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := runtimetest.NewFakeClient(tt.responses...)
+			r := runtime.NewRuntime(fake, newToolkit(), runtime.WithRetryPolicy(tt.policy))
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_, err := r.Run(ctx, userMessage("hi"))
+
+			if tt.wantErr == nil && err != nil || tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("Run() error = %v, want %v", err, tt.wantErr)
+			}
+			if got := len(fake.Requests()); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRunLoopLimits(t *testing.T) {
+	echo := func(id, text string) runtimetest.Response {
+		return runtimetest.CallTools(runtimetest.ToolCall(id, "echo", `{"text":"`+text+`"}`))
+	}
+	tests := []struct {
+		name      string
+		opts      []runtime.Option
+		responses []runtimetest.Response
+		wantErr   error
+	}{
+		{
+			name:      "within the limits",
+			opts:      []runtime.Option{runtime.WithMaxIterations(3), runtime.WithMaxToolCalls(2), runtime.WithMaxRepeatedToolCalls(1)},
+			responses: []runtimetest.Response{echo("c1", "a"), echo("c2", "b"), runtimetest.Reply("done")},
+		},
+		{
+			name:      "max iterations",
+			opts:      []runtime.Option{runtime.WithMaxIterations(2)},
+			responses: []runtimetest.Response{echo("c1", "a"), echo("c2", "b"), runtimetest.Reply("done")},
+			wantErr:   runtime.ErrMaxIterations,
+		},
+		{
+			name: "max tool calls",
+			opts: []runtime.Option{runtime.WithMaxToolCalls(2)},
+			responses: []runtimetest.Response{
+				runtimetest.CallTools(
+					runtimetest.ToolCall("c1", "echo", `{"text":"a"}`),
+					runtimetest.ToolCall("c2", "echo", `{"text":"b"}`),
+					runtimetest.ToolCall("c3", "echo", `{"text":"c"}`),
+				),
+				runtimetest.Reply("done"),
+			},
+			wantErr: runtime.ErrMaxToolCalls,
+		},
+		{
+			name:      "repeated tool call",
+			opts:      []runtime.Option{runtime.WithMaxRepeatedToolCalls(1)},
+			responses: []runtimetest.Response{echo("c1", "a"), echo("c2", "a"), runtimetest.Reply("done")},
+			wantErr:   runtime.ErrToolLoop,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := runtime.NewRuntime(runtimetest.NewFakeClient(tt.responses...), newTestToolkit(), tt.opts...)
+
+			_, err := r.Run(context.Background(), userMessage("hi"))
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("Run() error = %v", err)
+				}
+				return
+			}
+
+			var loopErr *runtime.LoopError
+			if !errors.Is(err, tt.wantErr) || !errors.As(err, &loopErr) {
+				t.Fatalf("Run() error = %v, want a *LoopError wrapping %v", err, tt.wantErr)
+			}
+			if len(loopErr.Messages) == 0 {
+				t.Errorf("LoopError carries no transcript")
+			}
+		})
+	}
+}
+
+func TestResumeStateFromJSON(t *testing.T) {
+	fake := runtimetest.NewFakeClient(runtimetest.CallTools(
+		runtimetest.ToolCall("c1", "echo", `{"text":"a"}`),
+		runtimetest.ToolCall("c2", "missing", `{}`),
+	))
+	var checkpoints int
+	var saved []byte
+	suspend := runtime.WithApprover(func(context.Context, openai.ToolCall) (runtime.Decision, error) {
+		return runtime.Suspended(), nil
+	})
+	r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithApprovalRequired("echo"), suspend,
+		runtime.WithCheckpointer(func(_ context.Context, state *runtime.State) error {
+			checkpoints++
+			var err error
+			saved, err = json.Marshal(state)
+			return err
+		}),
+	)
+
+	_, err := r.Run(context.Background(), userMessage("hi"))
+	if !errors.Is(err, runtime.ErrSuspended) {
+		t.Fatalf("Run() error = %v, want %v", err, runtime.ErrSuspended)
+	}
+	if checkpoints != 2 {
+		t.Errorf("checkpoints = %d, want one after the round and one after the tool calls", checkpoints)
+	}
+
+	var state runtime.State
+	if err = json.Unmarshal(saved, &state); err != nil {
+		t.Fatalf("decoding the saved state: %v", err)
+	}
+	if len(state.Pending) != 1 || state.Pending[0].ID != "c1" {
+		t.Fatalf("pending tool calls = %+v, want c1", state.Pending)
+	}
+	toolErr := state.ToolExecutions[0].Err
+	if toolErr == nil || toolErr.Kind != runtime.ToolErrorNotFound || toolErr.Err == nil {
+		t.Errorf("decoded tool error = %+v, want a not_found error with its cause", toolErr)
+	}
+
+	// a new process resumes the conversation with a fresh runtime
+	echo := newEchoTool()
+	fake = runtimetest.NewFakeClient(runtimetest.Reply("done"))
+	r = runtime.NewRuntime(fake, newToolkit(echo), runtime.WithApprovalRequired("echo"), suspend)
+	result, err := r.ResumeState(context.Background(), &state, map[string]runtime.Decision{"c1": runtime.Approved()})
+	if err != nil {
+		t.Fatalf("ResumeState() error = %v", err)
+	}
+
+	if echo.calls.Load() != 1 {
+		t.Errorf("tool executions = %d, want 1", echo.calls.Load())
+	}
+	if want := []string{"user", "assistant", "tool", "tool", "assistant"}; !slices.Equal(roles(result.Messages), want) {
+		t.Errorf("transcript roles = %v, want %v", roles(result.Messages), want)
+	}
+	if len(result.Rounds) != 2 || len(result.ToolExecutions) != 2 || result.NewMessages[len(result.NewMessages)-1].Content != "done" {
+		t.Errorf("result = %d rounds, %d tool executions, want the rounds and executions of both runs", len(result.Rounds), len(result.ToolExecutions))
+	}
+}
+
+func TestResumeStateAfterLoopLimit(t *testing.T) {
+	fake := runtimetest.NewFakeClient(runtimetest.CallTools(
+		runtimetest.ToolCall("c1", "echo", `{"text":"a"}`),
+		runtimetest.ToolCall("c2", "echo", `{"text":"b"}`),
+	))
+	var saved *runtime.State
+	r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithMaxToolCalls(1),
+		runtime.WithCheckpointer(func(_ context.Context, state *runtime.State) error {
+			saved = state
+			return nil
+		}),
+	)
+
+	result, err := r.Run(context.Background(), userMessage("hi"))
+	if !errors.Is(err, runtime.ErrMaxToolCalls) {
+		t.Fatalf("Run() error = %v, want %v", err, runtime.ErrMaxToolCalls)
+	}
+	if saved == nil || len(saved.Pending) != 2 {
+		t.Fatalf("checkpointed state = %+v, want both tool calls pending", saved)
+	}
+	if len(result.State.Pending) != 2 {
+		t.Errorf("result state pending = %+v, want both tool calls", result.State.Pending)
+	}
+
+	// the caller raises the limit and resumes the conversation
+	fake = runtimetest.NewFakeClient(runtimetest.Reply("done"))
+	r = runtime.NewRuntime(fake, newTestToolkit(), runtime.WithMaxToolCalls(10))
+	result, err = r.ResumeState(context.Background(), saved, nil)
+	if err != nil {
+		t.Fatalf("ResumeState() error = %v", err)
+	}
+	if got := toolResults(result.Messages); !slices.Equal(got, []string{"c1=echo:a", "c2=echo:b"}) {
+		t.Errorf("tool results = %v, want the results of c1 and c2", got)
+	}
+	if want := []string{"user", "assistant", "tool", "tool", "assistant"}; !slices.Equal(roles(result.Messages), want) {
+		t.Errorf("transcript roles = %v, want %v", roles(result.Messages), want)
+	}
+}
+
+// checkToolPairs fails unless every tool result follows the assistant message requesting it
+// and every tool call answered in original is still answered in trimmed.
+func checkToolPairs(t *testing.T, original, trimmed []openai.ChatCompletionMessage) {
+	t.Helper()
+
+	answered := func(messages []openai.ChatCompletionMessage) map[string]bool {
+		ids := make(map[string]bool)
+		for _, message := range messages {
+			if message.Role == openai.ChatMessageRoleTool {
+				ids[message.ToolCallID] = true
+			}
+		}
+		return ids
+	}
+	originalAnswers, trimmedAnswers := answered(original), answered(trimmed)
+
+	var requested map[string]bool
+	for i, message := range trimmed {
+		switch message.Role {
+		case openai.ChatMessageRoleAssistant:
+			requested = make(map[string]bool)
+			for _, toolCall := range message.ToolCalls {
+				requested[toolCall.ID] = true
+				if originalAnswers[toolCall.ID] && !trimmedAnswers[toolCall.ID] {
+					t.Errorf("message %d: tool call %s lost its result", i, toolCall.ID)
+				}
+			}
+		case openai.ChatMessageRoleTool:
+			if !requested[message.ToolCallID] {
+				t.Errorf("message %d: tool result %s is orphaned", i, message.ToolCallID)
+			}
+		default:
+			requested = nil
+		}
+	}
+}
+
+func TestContextStrategiesKeepToolResults(t *testing.T) {
+	big := strings.Repeat("x", 2000)
+	transcript := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: "system"},
+		{Role: openai.ChatMessageRoleUser, Content: big},
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+			runtimetest.ToolCall("c1", "echo", `{}`),
+			runtimetest.ToolCall("c2", "echo", `{}`),
+		}},
+		{Role: openai.ChatMessageRoleTool, Content: big, ToolCallID: "c1"},
+		{Role: openai.ChatMessageRoleTool, Content: big, ToolCallID: "c2"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "answer"},
+		{Role: openai.ChatMessageRoleUser, Content: "again"},
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{runtimetest.ToolCall("c3", "echo", `{}`)}},
+		{Role: openai.ChatMessageRoleTool, Content: big, ToolCallID: "c3"},
+	}
+	original := slices.Clone(transcript)
+
+	strategies := map[string]runtime.ContextStrategy{
+		"drop oldest":       runtime.DropOldestMessages(),
+		"drop tool outputs": runtime.DropOldToolOutputs(),
+	}
+	for n := 0; n <= len(transcript); n++ {
+		strategies[fmt.Sprintf("keep last %d", n)] = runtime.KeepLastMessages(n)
+	}
+
+	for name, strategy := range strategies {
+		for budget := 0; budget <= runtime.EstimateTokens(transcript); budget += 100 {
+			t.Run(fmt.Sprintf("%s within %d tokens", name, budget), func(t *testing.T) {
+				trimmed := strategy(transcript, budget)
+
+				checkToolPairs(t, transcript, trimmed)
+				if len(trimmed) == 0 || trimmed[0].Role != openai.ChatMessageRoleSystem {
+					t.Errorf("trimmed messages %v do not start with the system message", roles(trimmed))
+				}
+				if !reflect.DeepEqual(transcript, original) {
+					t.Fatalf("strategy modified the transcript")
+				}
+			})
+		}
+	}
+}
+
+func TestRunFitsContextWindow(t *testing.T) {
+	fake := runtimetest.NewFakeClient(runtimetest.Reply("done"))
+	r := runtime.NewRuntime(fake, newTestToolkit(),
+		runtime.WithContextWindow(2000),
+		runtime.WithContextStrategy(runtime.DropOldestMessages()),
+	)
+	transcript := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("x", 4000)},
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{runtimetest.ToolCall("c1", "echo", `{}`)}},
+		{Role: openai.ChatMessageRoleTool, Content: strings.Repeat("y", 4000), ToolCallID: "c1"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "answer"},
+		{Role: openai.ChatMessageRoleUser, Content: "and now?"},
+	}
+
+	result, err := r.Run(context.Background(), transcript)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	sent := fake.Requests()[0].Messages
+	checkToolPairs(t, transcript, sent)
+	if want := []string{"assistant", "user"}; !slices.Equal(roles(sent), want) {
+		t.Errorf("sent roles = %v, want %v", roles(sent), want)
+	}
+	if len(result.Messages) != len(transcript)+1 {
+		t.Errorf("transcript has %d messages, want the complete transcript of %d messages", len(result.Messages), len(transcript)+1)
+	}
+}
@@ -0,0 +1,292 @@
+package runtimetest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+)
+
+const redacted = "[REDACTED]"
+
+var (
+	ErrCassetteMismatch  = errors.New("runtimetest: request does not match cassette")
+	ErrCassetteExhausted = errors.New("runtimetest: no recorded interactions left")
+)
+
+// defaultRedactions matches OpenAI style API keys, which are always redacted.
+var defaultRedactions = []*regexp.Regexp{
+	regexp.MustCompile(`sk-[A-Za-z0-9_\-]{16,}`),
+}
+
+// Cassette is a recorded sequence of chat completion interactions.
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+type Interaction struct {
+	Request  openai.ChatCompletionRequest   `json:"request"`
+	Response *openai.ChatCompletionResponse `json:"response,omitempty"`
+	Error    *RecordedError                 `json:"error,omitempty"`
+}
+
+// RecordedError is a failed chat completion call. It is replayed as an *openai.APIError
+// if a status code was recorded, and as a plain error otherwise.
+type RecordedError struct {
+	Message        string `json:"message"`
+	HTTPStatusCode int    `json:"status_code,omitempty"`
+}
+
+func (e *RecordedError) err() error {
+	if e.HTTPStatusCode > 0 {
+		return &openai.APIError{Message: e.Message, HTTPStatusCode: e.HTTPStatusCode}
+	}
+	return errors.New(e.Message)
+}
+
+type CassetteOption func(r *redactor)
+
+// WithRedactions replaces every occurrence of the given secrets with [REDACTED]
+// before anything is written to or compared with a cassette.
+func WithRedactions(secrets ...string) CassetteOption {
+	return func(r *redactor) {
+		for _, secret := range secrets {
+			if secret != "" {
+				r.patterns = append(r.patterns, regexp.MustCompile(regexp.QuoteMeta(secret)))
+			}
+		}
+	}
+}
+
+// WithRedactionPatterns replaces every match of the given patterns with [REDACTED].
+func WithRedactionPatterns(patterns ...*regexp.Regexp) CassetteOption {
+	return func(r *redactor) {
+		r.patterns = append(r.patterns, patterns...)
+	}
+}
+
+type redactor struct {
+	patterns []*regexp.Regexp
+}
+
+func newRedactor(opts []CassetteOption) *redactor {
+	r := &redactor{patterns: append([]*regexp.Regexp(nil), defaultRedactions...)}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+func (r *redactor) string(s string) string {
+	for _, pattern := range r.patterns {
+		s = pattern.ReplaceAllString(s, redacted)
+	}
+	return s
+}
+
+// redact returns a copy of v with every secret replaced. The strings of v are redacted
+// after decoding its JSON representation, so secrets are found even if JSON escapes them.
+func redact[T any](r *redactor, v T) (T, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v, err
+	}
+
+	var tree any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err = decoder.Decode(&tree); err != nil {
+		return v, err
+	}
+	if data, err = json.Marshal(r.value(tree)); err != nil {
+		return v, err
+	}
+
+	var out T
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+// value redacts the strings and object keys of a decoded JSON value.
+func (r *redactor) value(v any) any {
+	switch v := v.(type) {
+	case string:
+		return r.string(v)
+	case []any:
+		for i, item := range v {
+			v[i] = r.value(item)
+		}
+		return v
+	case map[string]any:
+		redactedMap := make(map[string]any, len(v))
+		for key, item := range v {
+			redactedMap[r.string(key)] = r.value(item)
+		}
+		return redactedMap
+	default:
+		return v
+	}
+}
+
+// Recorder is a runtime.ChatClient that forwards every call to client and records
+// the redacted interaction. Call Save to write the cassette once the conversation is done.
+// Streaming is not recorded, streamed conversations are served by CreateChatCompletion instead.
+type Recorder struct {
+	client   runtime.ChatClient
+	path     string
+	redactor *redactor
+
+	mu       sync.Mutex
+	cassette Cassette
+}
+
+func NewRecorder(client runtime.ChatClient, path string, opts ...CassetteOption) *Recorder {
+	return &Recorder{
+		client:   client,
+		path:     path,
+		redactor: newRedactor(opts),
+	}
+}
+
+func (r *Recorder) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	response, err := r.client.CreateChatCompletion(ctx, request)
+
+	redactedRequest, redactErr := redact(r.redactor, normalize(request))
+	if redactErr != nil {
+		return response, redactErr
+	}
+
+	interaction := Interaction{Request: redactedRequest}
+	if err != nil {
+		recorded := &RecordedError{Message: r.redactor.string(err.Error())}
+		var apiErr *openai.APIError
+		if errors.As(err, &apiErr) {
+			recorded.Message = r.redactor.string(apiErr.Message)
+			recorded.HTTPStatusCode = apiErr.HTTPStatusCode
+		}
+		interaction.Error = recorded
+	} else {
+		redactedResponse, redactErr := redact(r.redactor, response)
+		if redactErr != nil {
+			return response, redactErr
+		}
+		interaction.Response = &redactedResponse
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, interaction)
+	r.mu.Unlock()
+
+	return response, err
+}
+
+// Save writes the recorded interactions to the cassette file.
+func (r *Recorder) Save() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, data, 0o600)
+}
+
+// Replayer is a runtime.ChatClient that serves the responses of a cassette in order.
+// Every request must match the recorded request after redaction, otherwise ErrCassetteMismatch is returned.
+type Replayer struct {
+	redactor *redactor
+
+	mu           sync.Mutex
+	interactions []Interaction
+}
+
+func NewReplayer(path string, opts ...CassetteOption) (*Replayer, error) {
+	data, err := os.ReadFile(path) // #nosec
+	if err != nil {
+		return nil, err
+	}
+
+	var cassette Cassette
+	if err = json.Unmarshal(data, &cassette); err != nil {
+		return nil, fmt.Errorf("runtimetest: invalid cassette %s: %w", path, err)
+	}
+
+	return &Replayer{
+		redactor:     newRedactor(opts),
+		interactions: cassette.Interactions,
+	}, nil
+}
+
+func (r *Replayer) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.interactions) == 0 {
+		return openai.ChatCompletionResponse{}, ErrCassetteExhausted
+	}
+
+	actual, err := redact(r.redactor, normalize(request))
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	actualJSON, _ := json.Marshal(actual)
+
+	interaction := r.interactions[0]
+	expectedJSON, _ := json.Marshal(interaction.Request)
+	if string(actualJSON) != string(expectedJSON) {
+		return openai.ChatCompletionResponse{}, fmt.Errorf("%w:\nexpected: %s\nactual:   %s", ErrCassetteMismatch, expectedJSON, actualJSON)
+	}
+	r.interactions = r.interactions[1:]
+
+	if interaction.Error != nil {
+		return openai.ChatCompletionResponse{}, interaction.Error.err()
+	}
+	if interaction.Response == nil {
+		return openai.ChatCompletionResponse{}, errors.New("runtimetest: recorded interaction has no response")
+	}
+	return *interaction.Response, nil
+}
+
+// Done returns an error if some recorded interactions were never replayed.
+func (r *Replayer) Done() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.interactions) > 0 {
+		return fmt.Errorf("runtimetest: %d recorded interactions were not replayed", len(r.interactions))
+	}
+	return nil
+}
+
+// normalize removes the parts of a request that differ between recording and replaying
+// without changing its meaning, such as the order of the tools.
+func normalize(request openai.ChatCompletionRequest) openai.ChatCompletionRequest {
+	request.Stream = false
+	tools := append([]openai.Tool(nil), request.Tools...)
+	sort.SliceStable(tools, func(i, j int) bool {
+		return toolName(tools[i]) < toolName(tools[j])
+	})
+	request.Tools = tools
+	return request
+}
+
+func toolName(tool openai.Tool) string {
+	if tool.Function == nil {
+		return ""
+	}
+	return tool.Function.Name
+}
@@ -0,0 +1,66 @@
+package runtimetest_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+func TestRecorderRedactions(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{name: "plain", secret: "hunter2"},
+		{name: "escaped by JSON", secret: `p&ss<word>"\`},
+		{name: "API key", secret: "sk-abcdefghijklmnopqrstuvwxyz"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "cassette.json")
+			request := openai.ChatCompletionRequest{
+				Model:    openai.GPT4TurboPreview,
+				Messages: []openai.ChatCompletionMessage{{Role: openai.ChatMessageRoleUser, Content: "my password is " + tt.secret}},
+			}
+
+			recorder := runtimetest.NewRecorder(runtimetest.NewFakeClient(runtimetest.Reply("it was "+tt.secret)), path, runtimetest.WithRedactions(tt.secret))
+			if _, err := recorder.CreateChatCompletion(context.Background(), request); err != nil {
+				t.Fatalf("CreateChatCompletion() error = %v", err)
+			}
+			if err := recorder.Save(); err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			escaped, _ := json.Marshal(tt.secret)
+			if strings.Contains(string(data), tt.secret) || strings.Contains(string(data), strings.Trim(string(escaped), `"`)) {
+				t.Errorf("cassette contains the secret:\n%s", data)
+			}
+			if !strings.Contains(string(data), "my password is [REDACTED]") {
+				t.Errorf("cassette does not contain the redacted request:\n%s", data)
+			}
+
+			replayer, err := runtimetest.NewReplayer(path, runtimetest.WithRedactions(tt.secret))
+			if err != nil {
+				t.Fatalf("NewReplayer() error = %v", err)
+			}
+			response, err := replayer.CreateChatCompletion(context.Background(), request)
+			if err != nil {
+				t.Fatalf("replayed CreateChatCompletion() error = %v", err)
+			}
+			if got := response.Choices[0].Message.Content; got != "it was [REDACTED]" {
+				t.Errorf("replayed content = %q, want %q", got, "it was [REDACTED]")
+			}
+		})
+	}
+}
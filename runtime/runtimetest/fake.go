@@ -0,0 +1,213 @@
+// Package runtimetest provides a scripted fake chat client for testing conversations
+// handled by the runtime without calling a real model.
+package runtimetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+)
+
+var ErrScriptExhausted = errors.New("runtimetest: no scripted responses left")
+
+// Response is a scripted reply of the fake model.
+type Response struct {
+	Message      openai.ChatCompletionMessage
+	FinishReason openai.FinishReason
+	Usage        openai.Usage
+	// Err is returned instead of a response when set.
+	Err error
+}
+
+// Reply scripts a final assistant message with the given content.
+func Reply(content string) Response {
+	return Response{
+		Message: openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: content,
+		},
+		FinishReason: openai.FinishReasonStop,
+	}
+}
+
+// CallTools scripts an assistant message asking for the given tool calls.
+func CallTools(toolCalls ...openai.ToolCall) Response {
+	return Response{
+		Message: openai.ChatCompletionMessage{
+			Role:      openai.ChatMessageRoleAssistant,
+			ToolCalls: toolCalls,
+		},
+		FinishReason: openai.FinishReasonToolCalls,
+	}
+}
+
+// Fail scripts an error returned by the chat completion call.
+func Fail(err error) Response {
+	return Response{Err: err}
+}
+
+// ToolCall builds a function tool call with the given id, tool name and raw JSON arguments.
+func ToolCall(id, name, arguments string) openai.ToolCall {
+	return openai.ToolCall{
+		ID:   id,
+		Type: openai.ToolTypeFunction,
+		Function: openai.FunctionCall{
+			Name:      name,
+			Arguments: arguments,
+		},
+	}
+}
+
+// FakeClient is a runtime.StreamingChatClient that replies with scripted responses in order
+// and records every request it receives. It is safe for concurrent use.
+type FakeClient struct {
+	mu       sync.Mutex
+	script   []Response
+	requests []openai.ChatCompletionRequest
+}
+
+var _ runtime.StreamingChatClient = (*FakeClient)(nil)
+
+func NewFakeClient(responses ...Response) *FakeClient {
+	return &FakeClient{
+		script: responses,
+	}
+}
+
+// Add appends responses to the script.
+func (c *FakeClient) Add(responses ...Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.script = append(c.script, responses...)
+}
+
+// Requests returns the requests received so far, in order.
+func (c *FakeClient) Requests() []openai.ChatCompletionRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]openai.ChatCompletionRequest(nil), c.requests...)
+}
+
+// Remaining returns the number of scripted responses not consumed yet.
+func (c *FakeClient) Remaining() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.script)
+}
+
+func (c *FakeClient) CreateChatCompletion(ctx context.Context, request openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+	if err := ctx.Err(); err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	n, response, err := c.next(request)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+
+	message := response.Message
+	if message.Role == "" {
+		message.Role = openai.ChatMessageRoleAssistant
+	}
+
+	return openai.ChatCompletionResponse{
+		ID:      fmt.Sprintf("fake-%d", n),
+		Object:  "chat.completion",
+		Model:   request.Model,
+		Choices: []openai.ChatCompletionChoice{{Message: message, FinishReason: response.FinishReason}},
+		Usage:   response.Usage,
+	}, nil
+}
+
+// CreateChatStream serves the next scripted response as a stream. Content is split into
+// words and the arguments of every tool call are split into two fragments.
+func (c *FakeClient) CreateChatStream(ctx context.Context, request openai.ChatCompletionRequest) (runtime.ChatStream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	n, response, err := c.next(request)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeStream{chunks: chunk(fmt.Sprintf("fake-%d", n), request.Model, response)}, nil
+}
+
+func (c *FakeClient) next(request openai.ChatCompletionRequest) (int, Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	request.Messages = append([]openai.ChatCompletionMessage(nil), request.Messages...)
+	c.requests = append(c.requests, request)
+
+	if len(c.script) == 0 {
+		return 0, Response{}, ErrScriptExhausted
+	}
+	response := c.script[0]
+	c.script = c.script[1:]
+	return len(c.requests), response, response.Err
+}
+
+func chunk(id, model string, response Response) []openai.ChatCompletionStreamResponse {
+	newChunk := func(delta openai.ChatCompletionStreamChoiceDelta) openai.ChatCompletionStreamResponse {
+		return openai.ChatCompletionStreamResponse{
+			ID:      id,
+			Object:  "chat.completion.chunk",
+			Model:   model,
+			Choices: []openai.ChatCompletionStreamChoice{{Delta: delta}},
+		}
+	}
+
+	chunks := []openai.ChatCompletionStreamResponse{
+		newChunk(openai.ChatCompletionStreamChoiceDelta{Role: openai.ChatMessageRoleAssistant}),
+	}
+
+	for _, word := range strings.SplitAfter(response.Message.Content, " ") {
+		if word != "" {
+			chunks = append(chunks, newChunk(openai.ChatCompletionStreamChoiceDelta{Content: word}))
+		}
+	}
+
+	for i, toolCall := range response.Message.ToolCalls {
+		index := i
+		args := toolCall.Function.Arguments
+		head, tail := args[:len(args)/2], args[len(args)/2:]
+
+		first := toolCall
+		first.Index = &index
+		first.Function.Arguments = head
+		chunks = append(chunks, newChunk(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{first}}))
+
+		chunks = append(chunks, newChunk(openai.ChatCompletionStreamChoiceDelta{ToolCalls: []openai.ToolCall{{
+			Index:    &index,
+			Function: openai.FunctionCall{Arguments: tail},
+		}}}))
+	}
+
+	last := newChunk(openai.ChatCompletionStreamChoiceDelta{})
+	last.Choices[0].FinishReason = response.FinishReason
+	return append(chunks, last)
+}
+
+type fakeStream struct {
+	chunks []openai.ChatCompletionStreamResponse
+}
+
+func (s *fakeStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.chunks) == 0 {
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	next := s.chunks[0]
+	s.chunks = s.chunks[1:]
+	return next, nil
+}
+
+func (s *fakeStream) Close() {}
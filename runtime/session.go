@@ -0,0 +1,175 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrNothingToResume is returned by Session.Resume when the session has no tool calls awaiting a decision.
+var ErrNothingToResume = errors.New("session has no pending tool calls to resume")
+
+// Session is a conversation whose transcript is kept in the store of the runtime,
+// so callers only pass the new user input.
+type Session struct {
+	runtime *Runtime
+	id      string
+}
+
+// sessionLock serializes the turns of a session, it is dropped once no turn holds it.
+type sessionLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// WithStore keeps the transcripts of sessions in store instead of in memory.
+func WithStore(store Store) Option {
+	return optionFunc(func(r *Runtime) {
+		r.store = store
+	})
+}
+
+// WithSystemMessage starts every new session with a system message.
+func WithSystemMessage(content string) Option {
+	return optionFunc(func(r *Runtime) {
+		r.systemMessage = content
+	})
+}
+
+// Session returns the session with the given ID. Sessions are created on their first message.
+func (r *Runtime) Session(id string) *Session {
+	return &Session{runtime: r, id: id}
+}
+
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Messages returns the transcript of the session.
+func (s *Session) Messages(ctx context.Context) ([]openai.ChatCompletionMessage, error) {
+	return s.runtime.store.Get(ctx, s.id)
+}
+
+// Reset clears the transcript of the session.
+func (s *Session) Reset(ctx context.Context) error {
+	unlock := s.runtime.lockSession(s.id)
+	defer unlock()
+	return s.runtime.store.Truncate(ctx, s.id, 0)
+}
+
+func (s *Session) Send(userText string, opts ...RequestOption) (*ChatResult, error) {
+	return s.SendContext(context.Background(), userText, opts...)
+}
+
+// SendContext adds a user message to the session and runs the conversation like Run.
+// The new messages are only saved if the conversation completes or is suspended,
+// so a failed turn can simply be sent again. Turns of the same session run one at a time.
+// A session with tool calls awaiting a decision returns a *SuspendedError until it is resumed with Resume.
+func (s *Session) SendContext(ctx context.Context, userText string, opts ...RequestOption) (*ChatResult, error) {
+	unlock := s.runtime.lockSession(s.id)
+	defer unlock()
+
+	messages, err := s.runtime.store.Get(ctx, s.id)
+	if err != nil {
+		return nil, err
+	}
+	if pending := pendingToolCalls(messages); len(pending) > 0 {
+		state := newState(messages)
+		state.ID = s.id
+		return nil, &SuspendedError{Messages: messages, Pending: pending, State: state}
+	}
+
+	stored := len(messages)
+	if stored == 0 && s.runtime.systemMessage != "" {
+		messages = append(messages, openai.ChatCompletionMessage{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: s.runtime.systemMessage,
+		})
+	}
+	messages = append(messages, openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleUser,
+		Content: userText,
+	})
+
+	return s.run(ctx, messages, stored, nil, opts)
+}
+
+// Resume continues a suspended session with decisions like Runtime.Resume.
+// It returns ErrNothingToResume if the session has no pending tool calls.
+func (s *Session) Resume(ctx context.Context, decisions map[string]Decision, opts ...RequestOption) (*ChatResult, error) {
+	unlock := s.runtime.lockSession(s.id)
+	defer unlock()
+
+	messages, err := s.runtime.store.Get(ctx, s.id)
+	if err != nil {
+		return nil, err
+	}
+	if len(pendingToolCalls(messages)) == 0 {
+		return nil, ErrNothingToResume
+	}
+
+	return s.run(ctx, messages, len(messages), decisions, opts)
+}
+
+// run runs a turn of the session, starting with the cached summary if the store keeps one.
+func (s *Session) run(ctx context.Context, messages []openai.ChatCompletionMessage, stored int, decisions map[string]Decision, opts []RequestOption) (*ChatResult, error) {
+	state := newState(messages)
+	state.ID = s.id
+	summaries, cached := s.runtime.store.(SummaryStore)
+	if cached {
+		summary, err := summaries.GetSummary(ctx, s.id)
+		if err != nil {
+			return nil, err
+		}
+		state.Summary = summary
+	}
+	previous := state.Summary
+
+	result, err := s.runtime.ResumeState(ctx, state, decisions, opts...)
+	if err = s.save(ctx, result, stored, err); err != nil && !errors.Is(err, ErrSuspended) {
+		return result, err
+	}
+	if cached && state.Summary != previous {
+		if saveErr := summaries.SetSummary(ctx, s.id, state.Summary); saveErr != nil {
+			return result, errors.Join(err, saveErr)
+		}
+	}
+	return result, err
+}
+
+// save appends the messages of result the store does not have yet and returns the error of the turn.
+func (s *Session) save(ctx context.Context, result *ChatResult, stored int, err error) error {
+	if err != nil && !errors.Is(err, ErrSuspended) {
+		return err
+	}
+	if saveErr := s.runtime.store.Append(ctx, s.id, result.Messages[stored:]...); saveErr != nil {
+		return errors.Join(err, saveErr)
+	}
+	return err
+}
+
+// lockSession blocks until no other turn of the session runs and returns the function releasing the session.
+func (r *Runtime) lockSession(id string) func() {
+	r.sessionsMu.Lock()
+	lock, ok := r.sessions[id]
+	if !ok {
+		lock = &sessionLock{}
+		r.sessions[id] = lock
+	}
+	lock.refs++
+	r.sessionsMu.Unlock()
+
+	lock.mu.Lock()
+	return func() {
+		lock.mu.Unlock()
+
+		r.sessionsMu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(r.sessions, id)
+		}
+		r.sessionsMu.Unlock()
+	}
+}
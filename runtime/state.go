@@ -0,0 +1,154 @@
+package runtime
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// State is the serializable state of a conversation. It can be encoded as JSON,
+// stored, and resumed with ResumeState, possibly by another process.
+type State struct {
+	// ID identifies the conversation, it is the session ID for conversations of a session.
+	ID       string                         `json:"id"`
+	Messages []openai.ChatCompletionMessage `json:"messages"`
+	// Initial is the number of messages the conversation was started with.
+	Initial int `json:"initial"`
+	// Pending are the tool calls requested by the model that have not been executed yet.
+	Pending        []openai.ToolCall   `json:"pending,omitempty"`
+	Rounds         []Round             `json:"rounds,omitempty"`
+	ToolExecutions []ToolExecution     `json:"tool_executions,omitempty"`
+	ToolCalls      int                 `json:"tool_calls"`
+	CallCounts     map[string]int      `json:"call_counts,omitempty"`
+	Usage          Usage               `json:"usage"`
+	Decisions      map[string]Decision `json:"decisions,omitempty"`
+	// Summary replaces the oldest messages in requests, see WithSummarization.
+	Summary *Summary `json:"summary,omitempty"`
+}
+
+// Checkpointer is called with the state of a conversation after every round and every batch of tool calls.
+// Returning an error stops the conversation with that error.
+type Checkpointer func(ctx context.Context, state *State) error
+
+// WithCheckpointer registers a checkpointer, e.g. to persist the state of long-running conversations.
+func WithCheckpointer(checkpointer Checkpointer) Option {
+	return optionFunc(func(r *Runtime) {
+		r.checkpointer = checkpointer
+	})
+}
+
+// newState starts a conversation with the given messages. Tool calls of the last assistant message
+// without results are pending, so a transcript ending with tool calls is continued by executing them.
+func newState(messages []openai.ChatCompletionMessage) *State {
+	return &State{
+		ID:         newStateID(),
+		Messages:   messages,
+		Initial:    len(messages),
+		Pending:    pendingToolCalls(messages),
+		CallCounts: make(map[string]int),
+	}
+}
+
+// ResumeState continues a conversation from a saved state. Pending tool calls are decided by decisions,
+// keyed by tool call ID, and by the approver for the tool calls without a decision.
+// The state is updated in place as the conversation progresses.
+func (r *Runtime) ResumeState(ctx context.Context, state *State, decisions map[string]Decision, opts ...RequestOption) (*ChatResult, error) {
+	if state.ID == "" {
+		state.ID = newStateID()
+	}
+	if state.CallCounts == nil {
+		state.CallCounts = make(map[string]int)
+	}
+	if len(decisions) > 0 {
+		if state.Decisions == nil {
+			state.Decisions = make(map[string]Decision, len(decisions))
+		}
+		for id, decision := range decisions {
+			state.Decisions[id] = decision
+		}
+	}
+
+	_, err := r.run(ctx, state, opts, nil)
+	return state.result(), err
+}
+
+func newStateID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func (r *Runtime) checkpoint(ctx context.Context, state *State) error {
+	if r.checkpointer == nil {
+		return nil
+	}
+	return r.checkpointer(ctx, state)
+}
+
+func (s *State) loopError(err error) *LoopError {
+	return &LoopError{
+		Err:       err,
+		Messages:  s.Messages,
+		Rounds:    len(s.Rounds),
+		ToolCalls: s.ToolCalls,
+	}
+}
+
+// pendingToolCalls returns the tool calls of the last assistant message that have no result yet.
+// Only an assistant message followed by nothing but tool results can have pending calls,
+// calls the conversation has moved past are never executed.
+func pendingToolCalls(messages []openai.ChatCompletionMessage) []openai.ToolCall {
+	i := len(messages) - 1
+	for i >= 0 && messages[i].Role == openai.ChatMessageRoleTool {
+		i--
+	}
+	if i < 0 || messages[i].Role != openai.ChatMessageRoleAssistant {
+		return nil
+	}
+
+	answered := make(map[string]bool)
+	for _, result := range messages[i+1:] {
+		answered[result.ToolCallID] = true
+	}
+
+	var pending []openai.ToolCall
+	for _, toolCall := range messages[i].ToolCalls {
+		if !answered[toolCall.ID] {
+			pending = append(pending, toolCall)
+		}
+	}
+	return pending
+}
+
+type toolErrorJSON struct {
+	Kind       ToolErrorKind `json:"kind"`
+	Tool       string        `json:"tool"`
+	ToolCallID string        `json:"tool_call_id"`
+	Error      string        `json:"error"`
+}
+
+// MarshalJSON encodes the error with its cause as a message, so a ToolError survives a round trip through State.
+func (e *ToolError) MarshalJSON() ([]byte, error) {
+	encoded := toolErrorJSON{Kind: e.Kind, Tool: e.Tool, ToolCallID: e.ToolCallID}
+	if e.Err != nil {
+		encoded.Error = e.Err.Error()
+	}
+	return json.Marshal(encoded)
+}
+
+func (e *ToolError) UnmarshalJSON(data []byte) error {
+	var decoded toolErrorJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	*e = ToolError{Kind: decoded.Kind, Tool: decoded.Tool, ToolCallID: decoded.ToolCallID}
+	if decoded.Error != "" {
+		e.Err = errors.New(decoded.Error)
+	}
+	return nil
+}
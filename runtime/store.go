@@ -0,0 +1,300 @@
+package runtime
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ErrInvalidSessionID is returned by a store for a session ID it cannot store.
+var ErrInvalidSessionID = errors.New("invalid session id")
+
+// Store persists the transcripts of sessions. A session that was never appended to is empty.
+type Store interface {
+	// Get returns the transcript of the session.
+	Get(ctx context.Context, sessionID string) ([]openai.ChatCompletionMessage, error)
+	// Append adds messages to the end of the transcript of the session.
+	Append(ctx context.Context, sessionID string, messages ...openai.ChatCompletionMessage) error
+	// Truncate keeps the first n messages of the transcript of the session, Truncate with 0 clears it.
+	Truncate(ctx context.Context, sessionID string, n int) error
+}
+
+// MemoryStore keeps transcripts in memory. It is the default store of a Runtime.
+type MemoryStore struct {
+	mu        sync.RWMutex
+	sessions  map[string][]openai.ChatCompletionMessage
+	summaries map[string]*Summary
+}
+
+var (
+	_ Store        = (*MemoryStore)(nil)
+	_ SummaryStore = (*MemoryStore)(nil)
+)
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		sessions:  make(map[string][]openai.ChatCompletionMessage),
+		summaries: make(map[string]*Summary),
+	}
+}
+
+func (s *MemoryStore) Get(_ context.Context, sessionID string) ([]openai.ChatCompletionMessage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]openai.ChatCompletionMessage(nil), s.sessions[sessionID]...), nil
+}
+
+func (s *MemoryStore) Append(_ context.Context, sessionID string, messages ...openai.ChatCompletionMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sessions[sessionID] = append(s.sessions[sessionID], messages...)
+	return nil
+}
+
+func (s *MemoryStore) Truncate(_ context.Context, sessionID string, n int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if summary := s.summaries[sessionID]; summary != nil && summary.Covers > n {
+		delete(s.summaries, sessionID)
+	}
+	if n <= 0 {
+		delete(s.sessions, sessionID)
+		return nil
+	}
+	if messages := s.sessions[sessionID]; n < len(messages) {
+		s.sessions[sessionID] = messages[:n:n]
+	}
+	return nil
+}
+
+func (s *MemoryStore) GetSummary(_ context.Context, sessionID string) (*Summary, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if summary := s.summaries[sessionID]; summary != nil {
+		copied := *summary
+		return &copied, nil
+	}
+	return nil, nil
+}
+
+func (s *MemoryStore) SetSummary(_ context.Context, sessionID string, summary *Summary) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if summary == nil {
+		delete(s.summaries, sessionID)
+		return nil
+	}
+	copied := *summary
+	s.summaries[sessionID] = &copied
+	return nil
+}
+
+// FileStore keeps every transcript in a JSONL file named after the session ID, one message per line.
+// The summary of a session is kept next to it in a JSON file.
+type FileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+var (
+	_ Store        = (*FileStore)(nil)
+	_ SummaryStore = (*FileStore)(nil)
+)
+
+// NewFileStore stores transcripts in dir, which is created if it does not exist.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) Get(_ context.Context, sessionID string) ([]openai.ChatCompletionMessage, error) {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readMessages(path)
+}
+
+func (s *FileStore) Append(_ context.Context, sessionID string, messages ...openai.ChatCompletionMessage) error {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	if err = writeMessages(file, messages); err != nil {
+		_ = file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (s *FileStore) Truncate(_ context.Context, sessionID string, n int) error {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	summary, err := readSummary(summaryPath(path))
+	if err != nil {
+		return err
+	}
+	if summary != nil && summary.Covers > n {
+		if err = removeFile(summaryPath(path)); err != nil {
+			return err
+		}
+	}
+
+	if n <= 0 {
+		return removeFile(path)
+	}
+
+	messages, err := readMessages(path)
+	if err != nil || n >= len(messages) {
+		return err
+	}
+
+	// write to a temporary file first so a failure never leaves a partial transcript behind
+	file, err := os.CreateTemp(s.dir, filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if err = writeMessages(file, messages[:n]); err != nil {
+		_ = file.Close()
+		_ = os.Remove(file.Name())
+		return err
+	}
+	if err = file.Close(); err != nil {
+		_ = os.Remove(file.Name())
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s *FileStore) GetSummary(_ context.Context, sessionID string) (*Summary, error) {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return readSummary(summaryPath(path))
+}
+
+func (s *FileStore) SetSummary(_ context.Context, sessionID string, summary *Summary) error {
+	path, err := s.path(sessionID)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if summary == nil {
+		return removeFile(summaryPath(path))
+	}
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(summaryPath(path), data, 0o644)
+}
+
+func (s *FileStore) path(sessionID string) (string, error) {
+	if !validFileName(sessionID) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidSessionID, sessionID)
+	}
+	return filepath.Join(s.dir, sessionID+".jsonl"), nil
+}
+
+// validFileName reports whether name can be used as a file name without leaving the directory of a store.
+func validFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && filepath.Base(name) == name
+}
+
+func summaryPath(path string) string {
+	return strings.TrimSuffix(path, ".jsonl") + ".summary.json"
+}
+
+func readSummary(path string) (*Summary, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var summary Summary
+	if err = json.Unmarshal(data, &summary); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &summary, nil
+}
+
+func removeFile(path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func readMessages(path string) ([]openai.ChatCompletionMessage, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var messages []openai.ChatCompletionMessage
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 64<<20) // tool results can be large
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var message openai.ChatCompletionMessage
+		if err = json.Unmarshal(scanner.Bytes(), &message); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		messages = append(messages, message)
+	}
+	return messages, scanner.Err()
+}
+
+func writeMessages(file *os.File, messages []openai.ChatCompletionMessage) error {
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	for _, message := range messages {
+		if err := encoder.Encode(message); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,210 @@
+package runtime_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+// summaryStore is a Store that caches summaries, as both built-in stores do.
+type summaryStore interface {
+	runtime.Store
+	runtime.SummaryStore
+}
+
+func TestStores(t *testing.T) {
+	stores := []struct {
+		name string
+		new  func(t *testing.T) summaryStore
+	}{
+		{name: "memory", new: func(*testing.T) summaryStore { return runtime.NewMemoryStore() }},
+		{name: "file", new: func(t *testing.T) summaryStore {
+			store, err := runtime.NewFileStore(filepath.Join(t.TempDir(), "sessions"))
+			if err != nil {
+				t.Fatalf("NewFileStore() error = %v", err)
+			}
+			return store
+		}},
+	}
+	transcript := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "first line\nsecond line"},
+		{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{runtimetest.ToolCall("c1", "echo", `{"text":"a"}`)}},
+		{Role: openai.ChatMessageRoleTool, ToolCallID: "c1", Content: "echo:a"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "done"},
+	}
+
+	for _, tt := range stores {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			t.Run("round trip", func(t *testing.T) {
+				store := tt.new(t)
+				if err := store.Append(ctx, "s1", transcript[:2]...); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+				if err := store.Append(ctx, "s1", transcript[2:]...); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+				got, err := store.Get(ctx, "s1")
+				if err != nil {
+					t.Fatalf("Get() error = %v", err)
+				}
+				if len(got) != len(transcript) || got[0].Content != transcript[0].Content ||
+					got[1].ToolCalls[0].Function.Arguments != `{"text":"a"}` || got[2].ToolCallID != "c1" {
+					t.Errorf("Get() = %+v, want %+v", got, transcript)
+				}
+				if got, err := store.Get(ctx, "unknown"); err != nil || len(got) != 0 {
+					t.Errorf("Get() of an unknown session = %v, %v, want an empty transcript", got, err)
+				}
+			})
+
+			t.Run("truncate", func(t *testing.T) {
+				store := tt.new(t)
+				if err := store.Append(ctx, "s1", transcript...); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+				for _, step := range []struct {
+					n    int
+					want []string
+				}{
+					{n: len(transcript) + 1, want: roles(transcript)},
+					{n: len(transcript), want: roles(transcript)},
+					{n: 2, want: []string{"user", "assistant"}},
+					{n: 0, want: nil},
+				} {
+					if err := store.Truncate(ctx, "s1", step.n); err != nil {
+						t.Fatalf("Truncate(%d) error = %v", step.n, err)
+					}
+					got, err := store.Get(ctx, "s1")
+					if err != nil {
+						t.Fatalf("Get() error = %v", err)
+					}
+					if !slices.Equal(roles(got), step.want) {
+						t.Errorf("after Truncate(%d) roles = %v, want %v", step.n, roles(got), step.want)
+					}
+				}
+			})
+
+			t.Run("summary", func(t *testing.T) {
+				store := tt.new(t)
+				if err := store.Append(ctx, "s1", transcript...); err != nil {
+					t.Fatalf("Append() error = %v", err)
+				}
+				if summary, err := store.GetSummary(ctx, "s1"); err != nil || summary != nil {
+					t.Fatalf("GetSummary() before SetSummary() = %v, %v, want none", summary, err)
+				}
+				if err := store.SetSummary(ctx, "s1", &runtime.Summary{Content: "greetings", Covers: 2}); err != nil {
+					t.Fatalf("SetSummary() error = %v", err)
+				}
+				summary, err := store.GetSummary(ctx, "s1")
+				if err != nil || summary == nil || summary.Content != "greetings" || summary.Covers != 2 {
+					t.Fatalf("GetSummary() = %+v, %v, want the summary covering 2 messages", summary, err)
+				}
+
+				// a truncation keeping the summarized messages keeps the summary
+				if err = store.Truncate(ctx, "s1", 3); err != nil {
+					t.Fatalf("Truncate() error = %v", err)
+				}
+				if summary, _ = store.GetSummary(ctx, "s1"); summary == nil {
+					t.Errorf("GetSummary() after Truncate(3) = nil, want the summary")
+				}
+				if err = store.Truncate(ctx, "s1", 1); err != nil {
+					t.Fatalf("Truncate() error = %v", err)
+				}
+				if summary, _ = store.GetSummary(ctx, "s1"); summary != nil {
+					t.Errorf("GetSummary() after Truncate(1) = %+v, want it invalidated", summary)
+				}
+			})
+		})
+	}
+}
+
+func TestFileStoreFiles(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	store, err := runtime.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: "multi\nline"},
+		{Role: openai.ChatMessageRoleAssistant, Content: "reply"},
+		{Role: openai.ChatMessageRoleUser, Content: "again"},
+	}
+	if err = store.Append(ctx, "s1", messages...); err != nil {
+		t.Fatalf("Append() error = %v", err)
+	}
+	if err = store.SetSummary(ctx, "s1", &runtime.Summary{Content: "summary", Covers: 1}); err != nil {
+		t.Fatalf("SetSummary() error = %v", err)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "s1.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Split(strings.TrimSpace(string(data)), "\n"); len(lines) != len(messages) {
+		t.Errorf("transcript has %d lines, want one per message:\n%s", len(lines), data)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "s1.summary.json")); err != nil {
+		t.Errorf("summary file: %v", err)
+	}
+
+	if err = store.Truncate(ctx, "s1", 2); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if want := []string{"s1.jsonl", "s1.summary.json"}; !slices.Equal(names, want) {
+		t.Errorf("files after Truncate() = %v, want %v without temporary files", names, want)
+	}
+
+	if err = store.Truncate(ctx, "s1", 0); err != nil {
+		t.Fatalf("Truncate() error = %v", err)
+	}
+	if entries, _ = os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("%d files left after clearing the session, want none", len(entries))
+	}
+}
+
+func TestFileStoreInvalidSessionIDs(t *testing.T) {
+	ctx := context.Background()
+	dir := filepath.Join(t.TempDir(), "sessions")
+	store, err := runtime.NewFileStore(dir)
+	if err != nil {
+		t.Fatalf("NewFileStore() error = %v", err)
+	}
+
+	message := openai.ChatCompletionMessage{Role: openai.ChatMessageRoleUser, Content: "hi"}
+	for _, id := range []string{"", ".", "..", "../x", "a/b"} {
+		if _, err := store.Get(ctx, id); !errors.Is(err, runtime.ErrInvalidSessionID) {
+			t.Errorf("Get(%q) error = %v, want %v", id, err, runtime.ErrInvalidSessionID)
+		}
+		if err := store.Append(ctx, id, message); !errors.Is(err, runtime.ErrInvalidSessionID) {
+			t.Errorf("Append(%q) error = %v, want %v", id, err, runtime.ErrInvalidSessionID)
+		}
+		if err := store.Truncate(ctx, id, 0); !errors.Is(err, runtime.ErrInvalidSessionID) {
+			t.Errorf("Truncate(%q) error = %v, want %v", id, err, runtime.ErrInvalidSessionID)
+		}
+		if err := store.SetSummary(ctx, id, &runtime.Summary{}); !errors.Is(err, runtime.ErrInvalidSessionID) {
+			t.Errorf("SetSummary(%q) error = %v, want %v", id, err, runtime.ErrInvalidSessionID)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Dir(dir)); len(entries) != 1 {
+		t.Errorf("files were written outside the store directory")
+	}
+}
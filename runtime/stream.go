@@ -0,0 +1,194 @@
+package runtime
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+type StreamEventType int
+
+const (
+	// StreamEventContent carries a content delta produced by the model.
+	StreamEventContent StreamEventType = iota
+	// StreamEventMessage carries a complete assistant message, including any assembled tool calls.
+	StreamEventMessage
+	// StreamEventToolResult carries the tool message produced by executing a tool call.
+	StreamEventToolResult
+)
+
+type StreamEvent struct {
+	Type    StreamEventType
+	Content string
+	Message *openai.ChatCompletionMessage
+}
+
+// StreamHandler receives the events of a streamed conversation.
+// It is always called from the goroutine running ProcessChatStream.
+type StreamHandler func(event StreamEvent)
+
+// ProcessChatStream runs the conversation loop like ProcessChatContext, but streams every round
+// and reports content deltas, assistant messages and tool results to handler as they arrive.
+func (r *Runtime) ProcessChatStream(ctx context.Context, messages []openai.ChatCompletionMessage, handler StreamHandler, opts ...RequestOption) ([]openai.ChatCompletionMessage, error) {
+	result, err := r.RunStream(ctx, messages, handler, opts...)
+	return result.Messages, err
+}
+
+func (r *Runtime) executeChatCompletionStream(ctx context.Context, req openai.ChatCompletionRequest, handler StreamHandler) (openai.ChatCompletionResponse, error) {
+	client, ok := asStreamingClient(r.client)
+	if !ok {
+		return r.executeChatCompletionFallback(ctx, req, handler)
+	}
+
+	stream, err := client.CreateChatStream(ctx, req)
+	if err != nil {
+		return openai.ChatCompletionResponse{}, err
+	}
+	defer stream.Close()
+
+	acc := &streamAccumulator{}
+	for {
+		chunk, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return openai.ChatCompletionResponse{}, err
+		}
+
+		if delta := acc.add(chunk); delta != "" {
+			handler(StreamEvent{Type: StreamEventContent, Content: delta})
+		}
+	}
+
+	response := acc.response()
+	handler(StreamEvent{Type: StreamEventMessage, Message: &response.Choices[0].Message})
+	return response, nil
+}
+
+// executeChatCompletionFallback serves a streamed round with a client that cannot stream,
+// reporting the whole content as a single delta.
+func (r *Runtime) executeChatCompletionFallback(ctx context.Context, req openai.ChatCompletionRequest, handler StreamHandler) (openai.ChatCompletionResponse, error) {
+	response, err := r.executeChatCompletion(ctx, req)
+	if err != nil {
+		return response, err
+	}
+	emitResponse(handler, response)
+	return response, nil
+}
+
+// emitResponse reports a complete response to handler as a single content delta followed by the message.
+func emitResponse(handler StreamHandler, response openai.ChatCompletionResponse) {
+	if len(response.Choices) == 0 {
+		return
+	}
+
+	message := response.Choices[0].Message
+	if message.Content != "" {
+		handler(StreamEvent{Type: StreamEventContent, Content: message.Content})
+	}
+	handler(StreamEvent{Type: StreamEventMessage, Message: &message})
+}
+
+// streamAccumulator assembles the chunks of a streamed chat completion into a single response.
+// Only the first choice is kept, as the runtime never requests more than one.
+type streamAccumulator struct {
+	id           string
+	model        string
+	created      int64
+	role         string
+	content      strings.Builder
+	toolCalls    []openai.ToolCall
+	finishReason openai.FinishReason
+}
+
+// add merges chunk into the accumulated response and returns the content delta it carried.
+func (a *streamAccumulator) add(chunk openai.ChatCompletionStreamResponse) string {
+	if a.id == "" {
+		a.id = chunk.ID
+		a.model = chunk.Model
+		a.created = chunk.Created
+	}
+	if len(chunk.Choices) == 0 {
+		return ""
+	}
+
+	choice := chunk.Choices[0]
+	if choice.Delta.Role != "" {
+		a.role = choice.Delta.Role
+	}
+	if choice.FinishReason != "" {
+		a.finishReason = choice.FinishReason
+	}
+	for _, fragment := range choice.Delta.ToolCalls {
+		a.addToolCall(fragment)
+	}
+
+	a.content.WriteString(choice.Delta.Content)
+	return choice.Delta.Content
+}
+
+// addToolCall merges a tool call fragment. The first fragment of a call carries its id, type and name,
+// the following fragments carry pieces of the arguments and are matched by their index.
+func (a *streamAccumulator) addToolCall(fragment openai.ToolCall) {
+	index := len(a.toolCalls) - 1
+	if fragment.Index != nil {
+		index = *fragment.Index
+	} else if fragment.ID != "" {
+		index = len(a.toolCalls)
+	}
+	if index < 0 {
+		index = 0
+	}
+
+	for len(a.toolCalls) <= index {
+		a.toolCalls = append(a.toolCalls, openai.ToolCall{Type: openai.ToolTypeFunction})
+	}
+
+	toolCall := &a.toolCalls[index]
+	if fragment.ID != "" {
+		toolCall.ID = fragment.ID
+	}
+	if fragment.Type != "" {
+		toolCall.Type = fragment.Type
+	}
+	toolCall.Function.Name += fragment.Function.Name
+	toolCall.Function.Arguments += fragment.Function.Arguments
+}
+
+func (a *streamAccumulator) response() openai.ChatCompletionResponse {
+	role := a.role
+	if role == "" {
+		role = openai.ChatMessageRoleAssistant
+	}
+
+	return openai.ChatCompletionResponse{
+		ID:      a.id,
+		Object:  "chat.completion",
+		Created: a.created,
+		Model:   a.model,
+		Choices: []openai.ChatCompletionChoice{
+			{
+				Message: openai.ChatCompletionMessage{
+					Role:      role,
+					Content:   a.content.String(),
+					ToolCalls: a.toolCalls,
+				},
+				FinishReason: a.finishReason,
+			},
+		},
+	}
+}
+
+// emitToolResults reports tool results to handler, if the conversation is streamed.
+func emitToolResults(handler StreamHandler, messages []openai.ChatCompletionMessage) {
+	if handler == nil {
+		return
+	}
+	for i := range messages {
+		handler(StreamEvent{Type: StreamEventToolResult, Message: &messages[i]})
+	}
+}
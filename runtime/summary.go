@@ -0,0 +1,157 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// DefaultSummaryPrompt instructs the model how to summarize the history of a conversation.
+const DefaultSummaryPrompt = "You summarize the history of a conversation between a user and an assistant that uses tools. " +
+	"Keep every fact, decision, open question and tool result the assistant may need to continue the conversation. " +
+	"Answer with the summary only."
+
+// DefaultSummaryKeepMessages is the number of recent messages that are never summarized unless SummaryPolicy.KeepMessages is set.
+const DefaultSummaryKeepMessages = 10
+
+// SummaryPolicy configures the summarization of old conversation history.
+type SummaryPolicy struct {
+	// Threshold is the estimated number of prompt tokens above which the history is summarized.
+	Threshold int
+	// KeepMessages is the number of recent messages that are kept verbatim.
+	KeepMessages int
+	// Model summarizes the history, the model of the conversation is used if empty.
+	Model string
+	// Prompt is the system prompt of the summarization request, DefaultSummaryPrompt if empty.
+	Prompt string
+}
+
+// Summary replaces the messages of a conversation before index Covers, except the leading system messages.
+type Summary struct {
+	Content string `json:"content"`
+	Covers  int    `json:"covers"`
+}
+
+// SummaryStore is implemented by stores that cache the summary of a session, so it is not regenerated every turn.
+type SummaryStore interface {
+	// GetSummary returns the summary of the session, or nil if there is none.
+	GetSummary(ctx context.Context, sessionID string) (*Summary, error)
+	SetSummary(ctx context.Context, sessionID string, summary *Summary) error
+}
+
+// WithSummarization replaces old messages with a summary written by the model once the prompt of a request
+// exceeds policy.Threshold. The summary is extended as the conversation grows and only the requests are
+// affected, the transcript of the conversation stays complete.
+func WithSummarization(policy SummaryPolicy) Option {
+	return optionFunc(func(r *Runtime) {
+		if policy.KeepMessages <= 0 {
+			policy.KeepMessages = DefaultSummaryKeepMessages
+		}
+		if policy.Prompt == "" {
+			policy.Prompt = DefaultSummaryPrompt
+		}
+		r.summaryPolicy = &policy
+	})
+}
+
+// contextMessages returns the messages sent to the model, with the summarized messages replaced by the summary.
+func (s *State) contextMessages() []openai.ChatCompletionMessage {
+	if s.Summary == nil || s.Summary.Covers > len(s.Messages) {
+		return s.Messages
+	}
+
+	head := min(leadingSystemMessages(s.Messages), s.Summary.Covers)
+	summary := openai.ChatCompletionMessage{
+		Role:    openai.ChatMessageRoleSystem,
+		Content: "Summary of the earlier conversation:\n" + s.Summary.Content,
+	}
+	return joinMessages(append(s.Messages[:head:head], summary), s.Messages[s.Summary.Covers:])
+}
+
+// summarize extends the summary of the conversation when its prompt exceeds the threshold of the summary policy.
+// A failed summarization is logged and the conversation continues with the previous summary.
+func (r *Runtime) summarize(ctx context.Context, state *State, opts []RequestOption) {
+	policy := r.summaryPolicy
+	if policy == nil || EstimateTokens(state.contextMessages()) <= policy.Threshold {
+		return
+	}
+
+	from := leadingSystemMessages(state.Messages)
+	if state.Summary != nil && state.Summary.Covers > from && state.Summary.Covers <= len(state.Messages) {
+		from = state.Summary.Covers
+	}
+	// never summarize an assistant message without the tool results answering it
+	cut := len(state.Messages) - policy.KeepMessages
+	for cut > from && state.Messages[cut].Role == openai.ChatMessageRoleTool {
+		cut--
+	}
+	if cut <= from {
+		return
+	}
+
+	content, err := r.createSummary(ctx, state, state.Messages[from:cut], opts)
+	if err != nil {
+		r.logger.Warn("summarizing the conversation failed", "err", err)
+		return
+	}
+	state.Summary = &Summary{Content: content, Covers: cut}
+}
+
+func (r *Runtime) createSummary(ctx context.Context, state *State, messages []openai.ChatCompletionMessage, opts []RequestOption) (string, error) {
+	var transcript strings.Builder
+	if state.Summary != nil {
+		fmt.Fprintf(&transcript, "Summary of the conversation so far:\n%s\n\nLater messages:\n", state.Summary.Content)
+	}
+	for _, message := range messages {
+		writeTranscriptMessage(&transcript, message)
+	}
+
+	return r.complete(ctx, state, r.summaryPolicy.Model, r.summaryPolicy.Prompt, transcript.String(), opts)
+}
+
+// complete sends a single request without tools, e.g. for a summary, and adds its usage to the conversation.
+// The model of the conversation is used if model is empty.
+func (r *Runtime) complete(ctx context.Context, state *State, model, prompt, content string, opts []RequestOption) (string, error) {
+	req := r.newRequest([]openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleSystem, Content: prompt},
+		{Role: openai.ChatMessageRoleUser, Content: content},
+	}, opts)
+	req.Tools = nil
+	req.ToolChoice = nil
+	req.ResponseFormat = nil
+	if model != "" {
+		req.Model = model
+	}
+
+	response, err := r.createChatCompletion(ctx, req, nil)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Choices) == 0 {
+		return "", ErrNoChoices
+	}
+
+	usage := r.prices.usageOf(response, req.Model)
+	state.Usage.Add(usage)
+	r.addUsage(usage)
+	return response.Choices[0].Message.Content, nil
+}
+
+func writeTranscriptMessage(transcript *strings.Builder, message openai.ChatCompletionMessage) {
+	content := message.Content
+	for _, part := range message.MultiContent {
+		content += part.Text
+	}
+
+	switch {
+	case message.Role == openai.ChatMessageRoleTool:
+		fmt.Fprintf(transcript, "tool result %s: %s\n", message.ToolCallID, content)
+	case content != "":
+		fmt.Fprintf(transcript, "%s: %s\n", message.Role, content)
+	}
+	for _, toolCall := range message.ToolCalls {
+		fmt.Fprintf(transcript, "%s called %s %s with %s\n", message.Role, toolCall.Function.Name, toolCall.ID, toolCall.Function.Arguments)
+	}
+}
@@ -0,0 +1,165 @@
+package runtime_test
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+func TestSummarization(t *testing.T) {
+	big := strings.Repeat("x", 4000)
+	tests := []struct {
+		name       string
+		threshold  int
+		transcript []openai.ChatCompletionMessage
+		wantCovers int
+		// wantRoles are the roles of the request answered by the final reply
+		wantRoles []string
+	}{
+		{
+			name:      "below the threshold",
+			threshold: 10000,
+			transcript: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: big},
+				{Role: openai.ChatMessageRoleAssistant, Content: "noted"},
+				{Role: openai.ChatMessageRoleUser, Content: "and now?"},
+			},
+			wantRoles: []string{"user", "assistant", "user"},
+		},
+		{
+			name:      "above the threshold",
+			threshold: 500,
+			transcript: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleSystem, Content: "system"},
+				{Role: openai.ChatMessageRoleUser, Content: big},
+				{Role: openai.ChatMessageRoleAssistant, Content: "noted"},
+				{Role: openai.ChatMessageRoleUser, Content: "and now?"},
+			},
+			wantCovers: 2,
+			wantRoles:  []string{"system", "system", "assistant", "user"},
+		},
+		{
+			name:      "tool results stay with their call",
+			threshold: 500,
+			transcript: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: big},
+				{Role: openai.ChatMessageRoleAssistant, ToolCalls: []openai.ToolCall{
+					runtimetest.ToolCall("c1", "echo", `{}`),
+					runtimetest.ToolCall("c2", "echo", `{}`),
+				}},
+				{Role: openai.ChatMessageRoleTool, ToolCallID: "c1", Content: "echo:"},
+				{Role: openai.ChatMessageRoleTool, ToolCallID: "c2", Content: "echo:"},
+				{Role: openai.ChatMessageRoleUser, Content: "and now?"},
+			},
+			// keeping 2 messages would cut between the tool results, so the cut moves before the assistant message
+			wantCovers: 1,
+			wantRoles:  []string{"system", "assistant", "tool", "tool", "user"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			responses := []runtimetest.Response{runtimetest.Reply("done")}
+			if tt.wantCovers > 0 {
+				responses = append([]runtimetest.Response{runtimetest.Reply("the user sent a lot of x")}, responses...)
+			}
+			fake := runtimetest.NewFakeClient(responses...)
+			r := runtime.NewRuntime(fake, newTestToolkit(), runtime.WithSummarization(runtime.SummaryPolicy{Threshold: tt.threshold, KeepMessages: 2}))
+
+			result, err := r.Run(context.Background(), tt.transcript)
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+
+			requests := fake.Requests()
+			if len(requests) != len(responses) {
+				t.Fatalf("requests = %d, want %d", len(requests), len(responses))
+			}
+			final := requests[len(requests)-1]
+			if !slices.Equal(roles(final.Messages), tt.wantRoles) {
+				t.Errorf("request roles = %v, want %v", roles(final.Messages), tt.wantRoles)
+			}
+			checkToolPairs(t, tt.transcript, final.Messages)
+			if len(result.Messages) != len(tt.transcript)+1 {
+				t.Errorf("transcript has %d messages, want it to stay complete", len(result.Messages))
+			}
+
+			summary := result.State.Summary
+			if tt.wantCovers == 0 {
+				if summary != nil {
+					t.Errorf("summary = %+v, want none", summary)
+				}
+				return
+			}
+			if summary == nil || summary.Covers != tt.wantCovers || summary.Content != "the user sent a lot of x" {
+				t.Fatalf("summary = %+v, want one covering %d messages", summary, tt.wantCovers)
+			}
+			if len(requests[0].Tools) != 0 || !strings.Contains(requests[0].Messages[1].Content, big) {
+				t.Errorf("summary request = %+v, want the summarized messages without tools", requests[0])
+			}
+			for _, message := range final.Messages {
+				if strings.Contains(message.Content, big) {
+					t.Errorf("request still contains the summarized message")
+				}
+			}
+		})
+	}
+}
+
+func TestSessionReusesSummary(t *testing.T) {
+	ctx := context.Background()
+	store := runtime.NewMemoryStore()
+	fake := runtimetest.NewFakeClient(
+		runtimetest.Reply("first answer"),
+		runtimetest.Reply("the user sent a lot of x"),
+		runtimetest.Reply("second answer"),
+		runtimetest.Reply("third answer"),
+		runtimetest.Reply("fourth answer"),
+	)
+	r := runtime.NewRuntime(fake, newTestToolkit(),
+		runtime.WithStore(store),
+		runtime.WithSummarization(runtime.SummaryPolicy{Threshold: 500, KeepMessages: 2}),
+	)
+	session := r.Session("s1")
+
+	for _, text := range []string{strings.Repeat("x", 4000), "second", "third"} {
+		if _, err := session.SendContext(ctx, text); err != nil {
+			t.Fatalf("Send(%.10q) error = %v", text, err)
+		}
+	}
+
+	// the first turn fits, the second one is summarized and the third one reuses the cached summary
+	requests := fake.Requests()
+	if len(requests) != 4 {
+		t.Fatalf("requests = %d, want 4 with a single summary request", len(requests))
+	}
+	third := requests[3].Messages
+	if third[0].Role != openai.ChatMessageRoleSystem || !strings.Contains(third[0].Content, "the user sent a lot of x") {
+		t.Errorf("third turn starts with %+v, want the cached summary", third[0])
+	}
+	if want := []string{"system", "assistant", "user", "assistant", "user"}; !slices.Equal(roles(third), want) {
+		t.Errorf("third turn roles = %v, want %v", roles(third), want)
+	}
+	if summary, err := store.GetSummary(ctx, "s1"); err != nil || summary == nil || summary.Covers != 1 {
+		t.Fatalf("stored summary = %+v, %v, want one covering the first message", summary, err)
+	}
+
+	// truncating the summarized messages invalidates the summary
+	if err := session.Reset(ctx); err != nil {
+		t.Fatalf("Reset() error = %v", err)
+	}
+	if summary, _ := store.GetSummary(ctx, "s1"); summary != nil {
+		t.Errorf("stored summary after Reset() = %+v, want none", summary)
+	}
+	if _, err := session.SendContext(ctx, "fourth"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if fourth := fake.Requests()[4].Messages; !slices.Equal(roles(fourth), []string{"user"}) {
+		t.Errorf("request after Reset() roles = %v, want only the new message", roles(fourth))
+	}
+}
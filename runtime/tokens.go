@@ -0,0 +1,54 @@
+package runtime
+
+import (
+	"encoding/json"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// The estimates follow the rule of thumb of roughly four characters per token
+// plus the fixed overhead the chat format adds to every message.
+const (
+	charsPerToken     = 4
+	tokensPerMessage  = 4
+	tokensPerRequest  = 3
+	tokensPerToolCall = 3
+)
+
+func estimateText(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// EstimateMessageTokens estimates the number of prompt tokens a single message takes.
+// The estimate is computed locally and is only meant for budgeting, not billing.
+func EstimateMessageTokens(message openai.ChatCompletionMessage) int {
+	tokens := tokensPerMessage + estimateText(message.Role) + estimateText(message.Content) + estimateText(message.Name)
+	for _, part := range message.MultiContent {
+		tokens += estimateText(part.Text)
+	}
+	for _, toolCall := range message.ToolCalls {
+		tokens += tokensPerToolCall + estimateText(toolCall.ID) + estimateText(toolCall.Function.Name) + estimateText(toolCall.Function.Arguments)
+	}
+	return tokens
+}
+
+// EstimateTokens estimates the number of prompt tokens the messages take.
+func EstimateTokens(messages []openai.ChatCompletionMessage) int {
+	tokens := tokensPerRequest
+	for _, message := range messages {
+		tokens += EstimateMessageTokens(message)
+	}
+	return tokens
+}
+
+// EstimateRequestTokens estimates the tokens a request consumes, including the tool definitions
+// and the completion tokens reserved with MaxTokens.
+func EstimateRequestTokens(req openai.ChatCompletionRequest) int {
+	tokens := EstimateTokens(req.Messages) + req.MaxTokens
+	if len(req.Tools) > 0 {
+		if definitions, err := json.Marshal(req.Tools); err == nil {
+			tokens += estimateText(string(definitions))
+		}
+	}
+	return tokens
+}
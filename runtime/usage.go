@@ -0,0 +1,67 @@
+package runtime
+
+import "github.com/sashabaranov/go-openai"
+
+// Usage is the token usage and cost of one or more chat completions.
+type Usage struct {
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"`
+	TotalTokens      int     `json:"total_tokens"`
+	Cost             float64 `json:"cost"`
+}
+
+func (u *Usage) Add(other Usage) {
+	u.PromptTokens += other.PromptTokens
+	u.CompletionTokens += other.CompletionTokens
+	u.TotalTokens += other.TotalTokens
+	u.Cost += other.Cost
+}
+
+// Price is the price of a model per 1000 tokens.
+type Price struct {
+	PromptPer1K     float64
+	CompletionPer1K float64
+}
+
+// PriceTable maps model names to their price.
+type PriceTable map[string]Price
+
+// Cost computes the cost of usage for the given model. Unknown models cost nothing.
+func (t PriceTable) Cost(model string, usage openai.Usage) float64 {
+	price, ok := t[model]
+	if !ok {
+		return 0
+	}
+	return float64(usage.PromptTokens)/1000*price.PromptPer1K +
+		float64(usage.CompletionTokens)/1000*price.CompletionPer1K
+}
+
+// usageOf converts the usage of a response into a Usage, pricing it by the model that served the response.
+// The requested model is used when the response model has no price, e.g. for Azure deployments.
+func (t PriceTable) usageOf(response openai.ChatCompletionResponse, requestedModel string) Usage {
+	model := response.Model
+	if _, ok := t[model]; !ok {
+		model = requestedModel
+	}
+
+	return Usage{
+		PromptTokens:     response.Usage.PromptTokens,
+		CompletionTokens: response.Usage.CompletionTokens,
+		TotalTokens:      response.Usage.TotalTokens,
+		Cost:             t.Cost(model, response.Usage),
+	}
+}
+
+// Usage returns the usage accumulated by all conversations of the runtime.
+// Streamed rounds do not report usage and are not included.
+func (r *Runtime) Usage() Usage {
+	r.usageMu.Lock()
+	defer r.usageMu.Unlock()
+	return r.usage
+}
+
+func (r *Runtime) addUsage(usage Usage) {
+	r.usageMu.Lock()
+	defer r.usageMu.Unlock()
+	r.usage.Add(usage)
+}
@@ -0,0 +1,151 @@
+package runtime_test
+
+import (
+	"context"
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+
+	"github.com/emilkje/go-openai-toolkit/runtime"
+	"github.com/emilkje/go-openai-toolkit/runtime/runtimetest"
+)
+
+// withUsage scripts the token usage reported with response.
+func withUsage(response runtimetest.Response, prompt, completion int) runtimetest.Response {
+	response.Usage = openai.Usage{PromptTokens: prompt, CompletionTokens: completion, TotalTokens: prompt + completion}
+	return response
+}
+
+// servedBy makes every response look like it was served by model, like Azure deployments do.
+func servedBy(model string) runtime.CompletionMiddleware {
+	return func(next runtime.CompletionHandler) runtime.CompletionHandler {
+		return func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+			response, err := next(ctx, req)
+			response.Model = model
+			return response, err
+		}
+	}
+}
+
+var testPrices = runtime.PriceTable{
+	openai.GPT4TurboPreview: {PromptPer1K: 0.01, CompletionPer1K: 0.03},
+	openai.GPT4:             {PromptPer1K: 0.005, CompletionPer1K: 0.015},
+}
+
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestPriceTableCost(t *testing.T) {
+	usage := openai.Usage{PromptTokens: 2000, CompletionTokens: 500, TotalTokens: 2500}
+	tests := []struct {
+		model string
+		want  float64
+	}{
+		{model: openai.GPT4TurboPreview, want: 0.02 + 0.015},
+		{model: openai.GPT4, want: 0.01 + 0.0075},
+		{model: "unknown", want: 0},
+	}
+	for _, tt := range tests {
+		if got := testPrices.Cost(tt.model, usage); !almostEqual(got, tt.want) {
+			t.Errorf("Cost(%s) = %v, want %v", tt.model, got, tt.want)
+		}
+	}
+}
+
+func TestUsagePricing(t *testing.T) {
+	tests := []struct {
+		name     string
+		servedBy string
+		wantCost float64
+	}{
+		{name: "requested model", wantCost: 0.01 + 0.03},
+		{name: "priced response model", servedBy: openai.GPT4, wantCost: 0.005 + 0.015},
+		{name: "unpriced response model falls back to the requested model", servedBy: "my-deployment", wantCost: 0.01 + 0.03},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := []runtime.Option{runtime.WithPriceTable(testPrices)}
+			if tt.servedBy != "" {
+				opts = append(opts, runtime.WithCompletionMiddleware(servedBy(tt.servedBy)))
+			}
+			fake := runtimetest.NewFakeClient(withUsage(runtimetest.Reply("done"), 1000, 1000))
+			r := runtime.NewRuntime(fake, newTestToolkit(), opts...)
+
+			result, err := r.Run(context.Background(), userMessage("hi"))
+			if err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if !almostEqual(result.Usage.Cost, tt.wantCost) {
+				t.Errorf("cost = %v, want %v", result.Usage.Cost, tt.wantCost)
+			}
+		})
+	}
+}
+
+func TestUsageAccumulation(t *testing.T) {
+	var responses []runtimetest.Response
+	for i := 0; i < 2; i++ {
+		responses = append(responses,
+			withUsage(runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"a"}`)), 100, 10),
+			withUsage(runtimetest.Reply("done"), 200, 20),
+		)
+	}
+	r := runtime.NewRuntime(runtimetest.NewFakeClient(responses...), newTestToolkit(), runtime.WithPriceTable(testPrices))
+
+	want := runtime.Usage{PromptTokens: 300, CompletionTokens: 30, TotalTokens: 330, Cost: 0.003 + 0.0009}
+	for i := 0; i < 2; i++ {
+		result, err := r.Run(context.Background(), userMessage("hi"))
+		if err != nil {
+			t.Fatalf("Run() error = %v", err)
+		}
+		if got := result.Usage; got.TotalTokens != want.TotalTokens || got.PromptTokens != want.PromptTokens || !almostEqual(got.Cost, want.Cost) {
+			t.Errorf("conversation usage = %+v, want %+v", got, want)
+		}
+		if len(result.Rounds) != 2 || result.Rounds[0].Usage.TotalTokens != 110 || result.Rounds[1].Usage.TotalTokens != 220 {
+			t.Errorf("rounds = %+v, want the usage of every round", result.Rounds)
+		}
+	}
+
+	// the runtime accumulates the usage of all its conversations
+	if got := r.Usage(); got.TotalTokens != 2*want.TotalTokens || !almostEqual(got.Cost, 2*want.Cost) {
+		t.Errorf("runtime usage = %+v, want the usage of both conversations", got)
+	}
+}
+
+func TestUsageIncludesSummaries(t *testing.T) {
+	fake := runtimetest.NewFakeClient(
+		// the conversation is summarized before the first round
+		withUsage(runtimetest.Reply("the user sent a lot of x"), 1000, 100),
+		withUsage(runtimetest.CallTools(runtimetest.ToolCall("c1", "echo", `{"text":"`+strings.Repeat("y", 400)+`"}`)), 100, 10),
+		// the oversized tool result is summarized
+		withUsage(runtimetest.Reply("many y"), 500, 50),
+		withUsage(runtimetest.Reply("done"), 200, 20),
+	)
+	r := runtime.NewRuntime(fake, newTestToolkit(),
+		runtime.WithPriceTable(testPrices),
+		runtime.WithSummarization(runtime.SummaryPolicy{Threshold: 500, KeepMessages: 1}),
+		runtime.WithOutputLimit(runtime.OutputLimit{MaxTokens: 20, Mode: runtime.SummarizeOutput}),
+	)
+
+	messages := []openai.ChatCompletionMessage{
+		{Role: openai.ChatMessageRoleUser, Content: strings.Repeat("x", 4000)},
+		{Role: openai.ChatMessageRoleAssistant, Content: "noted"},
+		{Role: openai.ChatMessageRoleUser, Content: "repeat y"},
+	}
+	result, err := r.Run(context.Background(), messages)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if got := len(fake.Requests()); got != 4 {
+		t.Fatalf("requests = %d, want 4", got)
+	}
+	if got := result.Usage.TotalTokens; got != 1100+110+550+220 {
+		t.Errorf("conversation tokens = %d, want the tokens of every request including the summaries", got)
+	}
+	if got := r.Usage(); got.TotalTokens != result.Usage.TotalTokens || !almostEqual(got.Cost, result.Usage.Cost) {
+		t.Errorf("runtime usage = %+v, want %+v", got, result.Usage)
+	}
+}
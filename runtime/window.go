@@ -0,0 +1,190 @@
+package runtime
+
+import (
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// ModelContextWindows are the context window sizes in tokens of well-known models, keyed by model name prefix.
+// The longest matching prefix wins, so dated snapshots share the window of their model.
+var ModelContextWindows = map[string]int{
+	"gpt-3.5-turbo":        16385,
+	"gpt-3.5-turbo-0301":   4096,
+	"gpt-3.5-turbo-0613":   4096,
+	"gpt-3.5-turbo-16k":    16385,
+	"gpt-4":                8192,
+	"gpt-4-32k":            32768,
+	"gpt-4-1106-preview":   128000,
+	"gpt-4-0125-preview":   128000,
+	"gpt-4-turbo":          128000,
+	"gpt-4-vision-preview": 128000,
+	"gpt-4.1":              1047576,
+	"gpt-4.5-preview":      128000,
+	"gpt-4o":               128000,
+	"o1":                   200000,
+	"o1-mini":              128000,
+	"o1-preview":           128000,
+	"o3":                   200000,
+	"o4-mini":              200000,
+}
+
+// defaultCompletionTokens are kept free for the completion of requests without MaxTokens.
+const defaultCompletionTokens = 1024
+
+// removedToolOutput replaces the content of tool results dropped by DropOldToolOutputs.
+const removedToolOutput = "[output removed to fit the context window]"
+
+// ContextWindow returns the context window size of model, or 0 if the model is unknown.
+func ContextWindow(model string) int {
+	window, matched := 0, -1
+	for prefix, size := range ModelContextWindows {
+		if strings.HasPrefix(model, prefix) && len(prefix) > matched {
+			window, matched = size, len(prefix)
+		}
+	}
+	return window
+}
+
+// ContextStrategy shortens the messages of a request that exceed budget tokens, as estimated by EstimateTokens.
+// A strategy must not modify messages and may return messages that still exceed the budget.
+type ContextStrategy func(messages []openai.ChatCompletionMessage, budget int) []openai.ChatCompletionMessage
+
+// WithContextStrategy fits every request into the context window of its model. When the estimated prompt
+// exceeds the window, the strategies are applied in order until it fits. Only the request is shortened,
+// the transcript of the conversation stays complete.
+func WithContextStrategy(strategies ...ContextStrategy) Option {
+	return optionFunc(func(r *Runtime) {
+		r.contextStrategies = append(r.contextStrategies, strategies...)
+	})
+}
+
+// WithContextWindow overrides the context window size of the model, e.g. for deployments with custom names.
+func WithContextWindow(tokens int) Option {
+	return optionFunc(func(r *Runtime) {
+		r.contextWindow = tokens
+	})
+}
+
+// DropOldestMessages drops the oldest messages after the leading system messages until the messages fit.
+// The last message, together with the tool results answering it, is always kept.
+func DropOldestMessages() ContextStrategy {
+	return func(messages []openai.ChatCompletionMessage, budget int) []openai.ChatCompletionMessage {
+		head := leadingSystemMessages(messages)
+		starts := groupStarts(messages, head)
+
+		tokens := EstimateTokens(messages)
+		from := head
+		for i := 0; i < len(starts)-1 && tokens > budget; i++ {
+			tokens -= estimateMessages(messages[starts[i]:starts[i+1]])
+			from = starts[i+1]
+		}
+		return joinMessages(messages[:head], messages[from:])
+	}
+}
+
+// KeepLastMessages keeps the leading system messages and the last n messages. When the n-th last message
+// is a tool result, the assistant message requesting it and its other results are kept as well.
+func KeepLastMessages(n int) ContextStrategy {
+	return func(messages []openai.ChatCompletionMessage, _ int) []openai.ChatCompletionMessage {
+		head := leadingSystemMessages(messages)
+		from := max(len(messages)-n, head)
+		for from > head && from < len(messages) && messages[from].Role == openai.ChatMessageRoleTool {
+			from--
+		}
+		return joinMessages(messages[:head], messages[from:])
+	}
+}
+
+// DropOldToolOutputs replaces the content of tool results with a placeholder, oldest first, until the messages fit.
+// The tool messages themselves are kept so every tool call still has its result, and the results
+// of the last round are left untouched.
+func DropOldToolOutputs() ContextStrategy {
+	return func(messages []openai.ChatCompletionMessage, budget int) []openai.ChatCompletionMessage {
+		starts := groupStarts(messages, 0)
+		if len(starts) == 0 {
+			return messages
+		}
+
+		trimmed := append([]openai.ChatCompletionMessage(nil), messages...)
+		tokens := EstimateTokens(trimmed)
+		for i := 0; i < starts[len(starts)-1] && tokens > budget; i++ {
+			if trimmed[i].Role != openai.ChatMessageRoleTool || trimmed[i].Content == removedToolOutput {
+				continue
+			}
+			before := EstimateMessageTokens(trimmed[i])
+			trimmed[i].Content = removedToolOutput
+			trimmed[i].MultiContent = nil
+			tokens -= before - EstimateMessageTokens(trimmed[i])
+		}
+		return trimmed
+	}
+}
+
+// fitContext shortens the messages of req with the context strategies when the request exceeds the context window.
+func (r *Runtime) fitContext(req *openai.ChatCompletionRequest) {
+	if len(r.contextStrategies) == 0 {
+		return
+	}
+	window := r.contextWindow
+	if window == 0 {
+		window = ContextWindow(req.Model)
+	}
+	if window == 0 {
+		return
+	}
+
+	// the tool definitions and the completion take their share of the window
+	budget := window - (EstimateRequestTokens(*req) - EstimateTokens(req.Messages))
+	if req.MaxTokens == 0 {
+		budget -= defaultCompletionTokens
+	}
+
+	tokens := EstimateTokens(req.Messages)
+	if tokens <= budget {
+		return
+	}
+	for _, strategy := range r.contextStrategies {
+		req.Messages = strategy(req.Messages, budget)
+		if tokens = EstimateTokens(req.Messages); tokens <= budget {
+			return
+		}
+	}
+	r.logger.Warn("messages exceed the context window", "model", req.Model, "tokens", tokens, "budget", budget)
+}
+
+// leadingSystemMessages returns the number of system messages the conversation starts with.
+func leadingSystemMessages(messages []openai.ChatCompletionMessage) int {
+	for i, message := range messages {
+		if message.Role != openai.ChatMessageRoleSystem {
+			return i
+		}
+	}
+	return len(messages)
+}
+
+// groupStarts returns the indices of the messages from offset on that start a group of messages
+// that must be kept or dropped together. Tool results belong to the group of the assistant message
+// requesting them, every other message starts a new group.
+func groupStarts(messages []openai.ChatCompletionMessage, offset int) []int {
+	var starts []int
+	for i := offset; i < len(messages); i++ {
+		if messages[i].Role != openai.ChatMessageRoleTool || len(starts) == 0 {
+			starts = append(starts, i)
+		}
+	}
+	return starts
+}
+
+func estimateMessages(messages []openai.ChatCompletionMessage) int {
+	tokens := 0
+	for _, message := range messages {
+		tokens += EstimateMessageTokens(message)
+	}
+	return tokens
+}
+
+func joinMessages(head, tail []openai.ChatCompletionMessage) []openai.ChatCompletionMessage {
+	joined := make([]openai.ChatCompletionMessage, 0, len(head)+len(tail))
+	return append(append(joined, head...), tail...)
+}
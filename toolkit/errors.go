@@ -0,0 +1,26 @@
+package toolkit
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrToolNotFound = errors.New("tool not found")
+	// ErrToolBusy is returned when a tool that is invoked one at a time is still in use by another invocation.
+	ErrToolBusy = errors.New("tool is busy")
+)
+
+// ArgumentError is returned by Toolkit.Invoke when the raw arguments could not be parsed.
+type ArgumentError struct {
+	Tool string
+	Err  error
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("invalid arguments for tool %s: %v", e.Tool, e.Err)
+}
+
+func (e *ArgumentError) Unwrap() error {
+	return e.Err
+}
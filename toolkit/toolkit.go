@@ -1,6 +1,13 @@
 package toolkit
 
-import "github.com/sashabaranov/go-openai"
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
 
 type Parsable interface {
 	ParseArgument(rawArgs string) error
@@ -10,6 +17,31 @@ type Executable interface {
 	Execute() string
 }
 
+// ContextExecutable is implemented by tools that want to observe cancellation
+// and report failures separately from their result.
+type ContextExecutable interface {
+	ExecuteContext(ctx context.Context) (string, error)
+}
+
+// Instantiable is implemented by tools that can create a fresh instance of themselves.
+// The toolkit uses it to give every invocation its own instance, so parsed arguments are never shared between calls.
+// The instance must implement the same interfaces as the tool. Tools generated by toolkit-tools-gen implement it.
+type Instantiable interface {
+	NewInstance() Definable
+}
+
+// Timed is implemented by tools that declare how long a single execution may take.
+// Tools generated by toolkit-tools-gen implement it when marked with +tool:timeout.
+type Timed interface {
+	Timeout() time.Duration
+}
+
+// Confirmable is implemented by tools that must be approved by a human before they are executed.
+// Tools generated by toolkit-tools-gen implement it when marked with +tool:confirm.
+type Confirmable interface {
+	RequiresConfirmation() bool
+}
+
 type Definable interface {
 	Definition() openai.FunctionDefinition
 }
@@ -19,32 +51,75 @@ type Callable interface {
 	Definable
 }
 
+// ContextCallable is a tool implementing ExecuteContext, registered with RegisterContextTool.
+type ContextCallable interface {
+	ContextExecutable
+	Definable
+}
+
+// Execute runs the tool, preferring ExecuteContext over Execute when both are implemented.
+func Execute(ctx context.Context, tool Definable) (string, error) {
+	switch t := tool.(type) {
+	case ContextExecutable:
+		return t.ExecuteContext(ctx)
+	case Executable:
+		return t.Execute(), nil
+	default:
+		return "", fmt.Errorf("tool %s is not executable", tool.Definition().Name)
+	}
+}
+
+// registration is a registered tool together with the semaphore used to serialize
+// invocations of tools that keep their arguments but cannot be instantiated.
+type registration struct {
+	tool Definable
+	sem  chan struct{}
+}
+
+// Toolkit is a registry of tools. It is safe for concurrent use.
 type Toolkit struct {
-	registry map[string]Callable
+	mu       sync.RWMutex
+	registry map[string]*registration
 }
 
 func NewToolkit() *Toolkit {
 	return &Toolkit{
-		registry: make(map[string]Callable),
+		registry: make(map[string]*registration),
 	}
 }
 
 func (t *Toolkit) RegisterTool(tool Callable, tools ...Callable) {
 	for _, callable := range append(tools, tool) {
-		name := callable.Definition().Name
-		if name == "" {
-			panic("Tool name cannot be empty")
-		}
-		if _, exists := t.registry[name]; !exists {
-			t.registry[name] = callable
-		}
+		t.register(callable)
+	}
+}
+
+// RegisterContextTool registers tools that implement ExecuteContext instead of Execute.
+func (t *Toolkit) RegisterContextTool(tool ContextCallable, tools ...ContextCallable) {
+	for _, callable := range append(tools, tool) {
+		t.register(callable)
+	}
+}
+
+func (t *Toolkit) register(tool Definable) {
+	name := tool.Definition().Name
+	if name == "" {
+		panic("Tool name cannot be empty")
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, exists := t.registry[name]; !exists {
+		t.registry[name] = &registration{tool: tool, sem: make(chan struct{}, 1)}
 	}
 }
 
 func (t *Toolkit) GetTools() []openai.Tool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	tools := make([]openai.Tool, 0, len(t.registry))
-	for _, tool := range t.registry {
-		toolDef := tool.Definition()
+	for _, reg := range t.registry {
+		toolDef := reg.tool.Definition()
 		tools = append(tools, openai.Tool{
 			Type:     openai.ToolTypeFunction,
 			Function: &toolDef,
@@ -53,17 +128,95 @@ func (t *Toolkit) GetTools() []openai.Tool {
 	return tools
 }
 
+// GetTool returns a tool registered with RegisterTool. Use Lookup for tools registered with RegisterContextTool.
 func (t *Toolkit) GetTool(name string) (Callable, bool) {
-	tool, exists := t.registry[name]
-	return tool, exists
+	reg, exists := t.find(name)
+	if !exists {
+		return nil, false
+	}
+	callable, ok := reg.tool.(Callable)
+	return callable, ok
+}
+
+// Lookup returns any registered tool, whether it implements Execute or ExecuteContext.
+func (t *Toolkit) Lookup(name string) (Definable, bool) {
+	reg, exists := t.find(name)
+	if !exists {
+		return nil, false
+	}
+	return reg.tool, true
+}
+
+// Invoke parses rawArgs and executes the named tool.
+// Every invocation of an Instantiable tool runs on a fresh instance. Other tools that keep their
+// arguments are invoked one at a time, so a Toolkit can be shared between concurrent conversations.
+func (t *Toolkit) Invoke(ctx context.Context, name string, rawArgs string) (string, error) {
+	tool, release, err := t.Prepare(ctx, name, rawArgs)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+	return Execute(ctx, tool)
+}
+
+// Prepare returns the named tool with rawArgs parsed, ready to be passed to Execute.
+// The caller must call release once the tool has been executed.
+//
+// A tool that keeps its arguments but is not Instantiable can only be prepared again once the previous
+// invocation has been released. If ctx is done while waiting, Prepare returns an error wrapping ErrToolBusy
+// and ctx.Err(). An invocation that never returns, e.g. because it ignores the cancellation of its context,
+// keeps such a tool busy for good. Implement Instantiable to avoid this.
+func (t *Toolkit) Prepare(ctx context.Context, name string, rawArgs string) (Definable, func(), error) {
+	reg, exists := t.find(name)
+	if !exists {
+		return nil, nil, fmt.Errorf("%w: %s", ErrToolNotFound, name)
+	}
+
+	tool := reg.tool
+	release := func() {}
+	if factory, ok := tool.(Instantiable); ok {
+		tool = factory.NewInstance()
+	} else if _, ok := tool.(Parsable); ok {
+		select {
+		case reg.sem <- struct{}{}:
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("%w: %s: %w", ErrToolBusy, name, ctx.Err())
+		}
+		release = func() { <-reg.sem }
+	}
+
+	// release the tool if parsing fails or panics
+	prepared := false
+	defer func() {
+		if !prepared {
+			release()
+		}
+	}()
+	if parser, ok := tool.(Parsable); ok {
+		if err := parser.ParseArgument(rawArgs); err != nil {
+			return nil, nil, &ArgumentError{Tool: name, Err: err}
+		}
+	}
+	prepared = true
+	return tool, release, nil
 }
 
 func (t *Toolkit) DefaultSystemMessage() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
 	toolDescriptions := ""
-	for _, tool := range t.registry {
-		toolDescriptions += "- " + tool.Definition().Name + ": " + tool.Definition().Description + "\n"
+	for _, reg := range t.registry {
+		toolDescriptions += "- " + reg.tool.Definition().Name + ": " + reg.tool.Definition().Description + "\n"
 	}
 	return "You are an assistant that has access to the following set of tools. Here are the names and descriptions for each tool:\n\n" +
 		toolDescriptions + "\n" +
 		"Given the user's input, you should be able to call the appropriate tools to provide the user with the information they need."
 }
+
+func (t *Toolkit) find(name string) (*registration, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	reg, exists := t.registry[name]
+	return reg, exists
+}
@@ -0,0 +1,132 @@
+package toolkit_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/sashabaranov/go-openai/jsonschema"
+
+	"github.com/emilkje/go-openai-toolkit/toolkit"
+)
+
+type textArgs struct {
+	Text string `json:"text"`
+}
+
+// plainTool keeps its arguments and cannot be instantiated, so the toolkit serializes its invocations.
+// If block is set, Execute signals started and waits until block is closed, ignoring cancellation.
+type plainTool struct {
+	toolkit.ToolArgs[textArgs]
+	started chan struct{}
+	block   chan struct{}
+}
+
+func (t *plainTool) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{Name: "plain", Parameters: jsonschema.Definition{Type: jsonschema.Object}}
+}
+
+func (t *plainTool) Execute() string {
+	text := t.GetArguments().Text
+	if t.block != nil {
+		t.started <- struct{}{}
+		<-t.block
+	}
+	return "plain:" + text
+}
+
+// instanceTool gets a fresh instance for every invocation.
+type instanceTool struct {
+	toolkit.Tool[textArgs]
+}
+
+func (t *instanceTool) Definition() openai.FunctionDefinition {
+	return openai.FunctionDefinition{Name: "instance", Parameters: jsonschema.Definition{Type: jsonschema.Object}}
+}
+
+func (t *instanceTool) Execute() string {
+	return "instance:" + t.GetArguments().Text
+}
+
+func (t *instanceTool) NewInstance() toolkit.Definable {
+	return &instanceTool{&toolkit.ToolArgs[textArgs]{}}
+}
+
+func TestInvokeConcurrently(t *testing.T) {
+	for _, name := range []string{"plain", "instance"} {
+		t.Run(name, func(t *testing.T) {
+			tk := toolkit.NewToolkit()
+			tk.RegisterTool(&plainTool{}, &instanceTool{&toolkit.ToolArgs[textArgs]{}})
+
+			var wg sync.WaitGroup
+			for i := 0; i < 32; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					got, err := tk.Invoke(context.Background(), name, fmt.Sprintf(`{"text":"%d"}`, i))
+					if err != nil {
+						t.Errorf("Invoke() error = %v", err)
+						return
+					}
+					if want := fmt.Sprintf("%s:%d", name, i); got != want {
+						t.Errorf("Invoke() = %q, want %q", got, want)
+					}
+				}()
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func TestInvokeBusyTool(t *testing.T) {
+	tool := &plainTool{started: make(chan struct{}, 1), block: make(chan struct{})}
+	tk := toolkit.NewToolkit()
+	tk.RegisterTool(tool)
+
+	// the first invocation is abandoned by its caller but keeps running
+	abandoned := make(chan string)
+	go func() {
+		result, _ := tk.Invoke(context.Background(), "plain", `{"text":"first"}`)
+		abandoned <- result
+	}()
+	<-tool.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := tk.Invoke(ctx, "plain", `{"text":"second"}`)
+	if !errors.Is(err, toolkit.ErrToolBusy) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Invoke() error = %v, want %v wrapping %v", err, toolkit.ErrToolBusy, context.DeadlineExceeded)
+	}
+
+	close(tool.block)
+	if got := <-abandoned; got != "plain:first" {
+		t.Errorf("abandoned Invoke() = %q, want %q", got, "plain:first")
+	}
+	if got, err := tk.Invoke(context.Background(), "plain", `{"text":"third"}`); err != nil || got != "plain:third" {
+		t.Errorf("Invoke() after release = %q, %v, want %q", got, err, "plain:third")
+	}
+}
+
+func TestInvokeErrors(t *testing.T) {
+	tk := toolkit.NewToolkit()
+	tk.RegisterTool(&plainTool{})
+
+	if _, err := tk.Invoke(context.Background(), "missing", `{}`); !errors.Is(err, toolkit.ErrToolNotFound) {
+		t.Errorf("Invoke() error = %v, want %v", err, toolkit.ErrToolNotFound)
+	}
+
+	var argErr *toolkit.ArgumentError
+	if _, err := tk.Invoke(context.Background(), "plain", `{"text":`); !errors.As(err, &argErr) {
+		t.Errorf("Invoke() error = %v, want an *ArgumentError", err)
+	}
+	// a failed parse releases the tool
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if got, err := tk.Invoke(ctx, "plain", `{"text":"ok"}`); err != nil || got != "plain:ok" {
+		t.Errorf("Invoke() after invalid arguments = %q, %v, want %q", got, err, "plain:ok")
+	}
+}